@@ -1,9 +1,15 @@
 package pingpong
 
-import "golang.org/x/net/context"
-import google_protobuf_empty "github.com/golang/protobuf/ptypes/empty"
+import (
+	"fmt"
+	"io"
 
-//go:generate protoc -I. -I$GOPATH/src -I$PWD/vendor -I$PWD/vendor/github.com/grpc-ecosystem/grpc-gateway/third_party/googleapis --go_out=plugins=grpc:. --grpc-gateway_out=request_context=true,logtostderr=true:. pingpong.proto
+	google_protobuf_empty "github.com/golang/protobuf/ptypes/empty"
+	"golang.org/x/net/context"
+)
+
+//go:generate protoc -I. -I$GOPATH/src -I$PWD/vendor -I$PWD/vendor/github.com/grpc-ecosystem/grpc-gateway/third_party/googleapis --go_out=plugins=grpc:. --grpc-gateway_out=request_context=true,logtostderr=true:. --swagger_out=logtostderr=true:. pingpong.proto
+//go:generate sh -c "(echo '// Code generated by go generate. DO NOT EDIT.'; echo; echo 'package pingpong'; echo; echo '// Swagger is the OpenAPI document of pingpong.proto'; echo 'const Swagger = `'; cat pingpong.swagger.json; echo '`') > pingpong.swagger.pb.go"
 
 // server is used to implement PingPong service
 type PingPongServerImpl struct{}
@@ -16,3 +22,45 @@ func (s *PingPongServerImpl) Ping(ctx context.Context, in *PingRequest) (*PongRe
 func (s *PingPongServerImpl) NoPing(ctx context.Context, in *google_protobuf_empty.Empty) (*PongReply, error) {
 	return &PongReply{Message: "HelloPong"}, nil
 }
+
+func (s *PingPongServerImpl) PingStream(in *PingStreamRequest, stream PingPong_PingStreamServer) error {
+	for i := int32(0); i < in.GetCount(); i++ {
+		if err := stream.Send(&PongReply{Message: fmt.Sprintf("Hello %s %d", in.GetSender(), i)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PingPongServerImpl) PingCollect(stream PingPong_PingCollectServer) error {
+	n := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&PongReply{Message: fmt.Sprintf("Hello %d pings", n)})
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+}
+
+func (s *PingPongServerImpl) PingPongStream(stream PingPong_PingPongStreamServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&PongReply{Message: "Hello " + in.GetSender()}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *PingPongServerImpl) PingPayload(ctx context.Context, in *Payload) (*Payload, error) {
+	return in, nil
+}
@@ -9,8 +9,11 @@ It is generated from these files:
 
 It has these top-level messages:
 	PingRequest
+	PingStreamRequest
 	PongReply
 	PongReply2
+	Payload
+	PayloadItem
 */
 package pingpong
 
@@ -52,6 +55,30 @@ func (m *PingRequest) GetSender() string {
 	return ""
 }
 
+type PingStreamRequest struct {
+	Sender string `protobuf:"bytes,1,opt,name=sender" json:"sender,omitempty"`
+	Count  int32  `protobuf:"varint,2,opt,name=count" json:"count,omitempty"`
+}
+
+func (m *PingStreamRequest) Reset()                    { *m = PingStreamRequest{} }
+func (m *PingStreamRequest) String() string            { return proto.CompactTextString(m) }
+func (*PingStreamRequest) ProtoMessage()               {}
+func (*PingStreamRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *PingStreamRequest) GetSender() string {
+	if m != nil {
+		return m.Sender
+	}
+	return ""
+}
+
+func (m *PingStreamRequest) GetCount() int32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
 type PongReply struct {
 	Message string `protobuf:"bytes,1,opt,name=message" json:"message,omitempty"`
 }
@@ -59,7 +86,7 @@ type PongReply struct {
 func (m *PongReply) Reset()                    { *m = PongReply{} }
 func (m *PongReply) String() string            { return proto.CompactTextString(m) }
 func (*PongReply) ProtoMessage()               {}
-func (*PongReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*PongReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
 func (m *PongReply) GetMessage() string {
 	if m != nil {
@@ -75,7 +102,7 @@ type PongReply2 struct {
 func (m *PongReply2) Reset()                    { *m = PongReply2{} }
 func (m *PongReply2) String() string            { return proto.CompactTextString(m) }
 func (*PongReply2) ProtoMessage()               {}
-func (*PongReply2) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*PongReply2) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
 func (m *PongReply2) GetMessage() string {
 	if m != nil {
@@ -84,10 +111,77 @@ func (m *PongReply2) GetMessage() string {
 	return ""
 }
 
+type Payload struct {
+	Sender string         `protobuf:"bytes,1,opt,name=sender" json:"sender,omitempty"`
+	Data   []byte         `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Items  []*PayloadItem `protobuf:"bytes,3,rep,name=items" json:"items,omitempty"`
+}
+
+func (m *Payload) Reset()                    { *m = Payload{} }
+func (m *Payload) String() string            { return proto.CompactTextString(m) }
+func (*Payload) ProtoMessage()               {}
+func (*Payload) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *Payload) GetSender() string {
+	if m != nil {
+		return m.Sender
+	}
+	return ""
+}
+
+func (m *Payload) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *Payload) GetItems() []*PayloadItem {
+	if m != nil {
+		return m.Items
+	}
+	return nil
+}
+
+type PayloadItem struct {
+	Key       string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value     []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Timestamp int64  `protobuf:"varint,3,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *PayloadItem) Reset()                    { *m = PayloadItem{} }
+func (m *PayloadItem) String() string            { return proto.CompactTextString(m) }
+func (*PayloadItem) ProtoMessage()               {}
+func (*PayloadItem) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *PayloadItem) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *PayloadItem) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *PayloadItem) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*PingRequest)(nil), "pingpong.PingRequest")
+	proto.RegisterType((*PingStreamRequest)(nil), "pingpong.PingStreamRequest")
 	proto.RegisterType((*PongReply)(nil), "pingpong.PongReply")
 	proto.RegisterType((*PongReply2)(nil), "pingpong.PongReply2")
+	proto.RegisterType((*Payload)(nil), "pingpong.Payload")
+	proto.RegisterType((*PayloadItem)(nil), "pingpong.PayloadItem")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -103,6 +197,14 @@ const _ = grpc.SupportPackageIsVersion4
 type PingPongClient interface {
 	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PongReply, error)
 	NoPing(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*PongReply, error)
+	// PingStream replies count times to a single ping
+	PingStream(ctx context.Context, in *PingStreamRequest, opts ...grpc.CallOption) (PingPong_PingStreamClient, error)
+	// PingCollect replies once to all pings of the stream
+	PingCollect(ctx context.Context, opts ...grpc.CallOption) (PingPong_PingCollectClient, error)
+	// PingPongStream replies to every ping of the stream
+	PingPongStream(ctx context.Context, opts ...grpc.CallOption) (PingPong_PingPongStreamClient, error)
+	// PingPayload echoes the payload
+	PingPayload(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*Payload, error)
 }
 
 type pingPongClient struct {
@@ -131,11 +233,125 @@ func (c *pingPongClient) NoPing(ctx context.Context, in *google_protobuf1.Empty,
 	return out, nil
 }
 
+func (c *pingPongClient) PingStream(ctx context.Context, in *PingStreamRequest, opts ...grpc.CallOption) (PingPong_PingStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_PingPong_serviceDesc.Streams[0], c.cc, "/pingpong.PingPong/PingStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pingPongPingStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type PingPong_PingStreamClient interface {
+	Recv() (*PongReply, error)
+	grpc.ClientStream
+}
+
+type pingPongPingStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *pingPongPingStreamClient) Recv() (*PongReply, error) {
+	m := new(PongReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *pingPongClient) PingCollect(ctx context.Context, opts ...grpc.CallOption) (PingPong_PingCollectClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_PingPong_serviceDesc.Streams[1], c.cc, "/pingpong.PingPong/PingCollect", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pingPongPingCollectClient{stream}
+	return x, nil
+}
+
+type PingPong_PingCollectClient interface {
+	Send(*PingRequest) error
+	CloseAndRecv() (*PongReply, error)
+	grpc.ClientStream
+}
+
+type pingPongPingCollectClient struct {
+	grpc.ClientStream
+}
+
+func (x *pingPongPingCollectClient) Send(m *PingRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *pingPongPingCollectClient) CloseAndRecv() (*PongReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PongReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *pingPongClient) PingPongStream(ctx context.Context, opts ...grpc.CallOption) (PingPong_PingPongStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_PingPong_serviceDesc.Streams[2], c.cc, "/pingpong.PingPong/PingPongStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pingPongPingPongStreamClient{stream}
+	return x, nil
+}
+
+type PingPong_PingPongStreamClient interface {
+	Send(*PingRequest) error
+	Recv() (*PongReply, error)
+	grpc.ClientStream
+}
+
+type pingPongPingPongStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *pingPongPingPongStreamClient) Send(m *PingRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *pingPongPingPongStreamClient) Recv() (*PongReply, error) {
+	m := new(PongReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *pingPongClient) PingPayload(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*Payload, error) {
+	out := new(Payload)
+	err := grpc.Invoke(ctx, "/pingpong.PingPong/PingPayload", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for PingPong service
 
 type PingPongServer interface {
 	Ping(context.Context, *PingRequest) (*PongReply, error)
 	NoPing(context.Context, *google_protobuf1.Empty) (*PongReply, error)
+	// PingStream replies count times to a single ping
+	PingStream(*PingStreamRequest, PingPong_PingStreamServer) error
+	// PingCollect replies once to all pings of the stream
+	PingCollect(PingPong_PingCollectServer) error
+	// PingPongStream replies to every ping of the stream
+	PingPongStream(PingPong_PingPongStreamServer) error
+	// PingPayload echoes the payload
+	PingPayload(context.Context, *Payload) (*Payload, error)
 }
 
 func RegisterPingPongServer(s *grpc.Server, srv PingPongServer) {
@@ -178,6 +394,97 @@ func _PingPong_NoPing_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PingPong_PingStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PingStreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PingPongServer).PingStream(m, &pingPongPingStreamServer{stream})
+}
+
+type PingPong_PingStreamServer interface {
+	Send(*PongReply) error
+	grpc.ServerStream
+}
+
+type pingPongPingStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *pingPongPingStreamServer) Send(m *PongReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _PingPong_PingCollect_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PingPongServer).PingCollect(&pingPongPingCollectServer{stream})
+}
+
+type PingPong_PingCollectServer interface {
+	SendAndClose(*PongReply) error
+	Recv() (*PingRequest, error)
+	grpc.ServerStream
+}
+
+type pingPongPingCollectServer struct {
+	grpc.ServerStream
+}
+
+func (x *pingPongPingCollectServer) SendAndClose(m *PongReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *pingPongPingCollectServer) Recv() (*PingRequest, error) {
+	m := new(PingRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _PingPong_PingPongStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PingPongServer).PingPongStream(&pingPongPingPongStreamServer{stream})
+}
+
+type PingPong_PingPongStreamServer interface {
+	Send(*PongReply) error
+	Recv() (*PingRequest, error)
+	grpc.ServerStream
+}
+
+type pingPongPingPongStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *pingPongPingPongStreamServer) Send(m *PongReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *pingPongPingPongStreamServer) Recv() (*PingRequest, error) {
+	m := new(PingRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _PingPong_PingPayload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Payload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PingPongServer).PingPayload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pingpong.PingPong/PingPayload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PingPongServer).PingPayload(ctx, req.(*Payload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _PingPong_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pingpong.PingPong",
 	HandlerType: (*PingPongServer)(nil),
@@ -190,28 +497,61 @@ var _PingPong_serviceDesc = grpc.ServiceDesc{
 			MethodName: "NoPing",
 			Handler:    _PingPong_NoPing_Handler,
 		},
+		{
+			MethodName: "PingPayload",
+			Handler:    _PingPong_PingPayload_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PingStream",
+			Handler:       _PingPong_PingStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PingCollect",
+			Handler:       _PingPong_PingCollect_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "PingPongStream",
+			Handler:       _PingPong_PingPongStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "pingpong.proto",
 }
 
 func init() { proto.RegisterFile("pingpong.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 236 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2b, 0xc8, 0xcc, 0x4b,
-	0x2f, 0xc8, 0xcf, 0x4b, 0xd7, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x80, 0xf1, 0xa5, 0x64,
-	0xd2, 0xf3, 0xf3, 0xd3, 0x73, 0x52, 0xf5, 0x13, 0x0b, 0x32, 0xf5, 0x13, 0xf3, 0xf2, 0xf2, 0x4b,
-	0x12, 0x4b, 0x32, 0xf3, 0xf3, 0x8a, 0x21, 0xea, 0xa4, 0xa4, 0xa1, 0xb2, 0x60, 0x5e, 0x52, 0x69,
-	0x9a, 0x7e, 0x6a, 0x6e, 0x41, 0x49, 0x25, 0x44, 0x52, 0x49, 0x95, 0x8b, 0x3b, 0x20, 0x33, 0x2f,
-	0x3d, 0x28, 0xb5, 0xb0, 0x34, 0xb5, 0xb8, 0x44, 0x48, 0x8c, 0x8b, 0xad, 0x38, 0x35, 0x2f, 0x25,
-	0xb5, 0x48, 0x82, 0x51, 0x81, 0x51, 0x83, 0x33, 0x08, 0xca, 0x53, 0x52, 0xe5, 0xe2, 0x0c, 0xc8,
-	0x07, 0x29, 0x2b, 0xc8, 0xa9, 0x14, 0x92, 0xe0, 0x62, 0xcf, 0x4d, 0x2d, 0x2e, 0x4e, 0x4c, 0x4f,
-	0x85, 0xaa, 0x82, 0x71, 0x95, 0xd4, 0xb8, 0xb8, 0xe0, 0xca, 0x8c, 0x70, 0xab, 0x33, 0x9a, 0xc6,
-	0xc8, 0xc5, 0x01, 0xb2, 0x16, 0xa4, 0x58, 0xc8, 0x85, 0x8b, 0x05, 0xc4, 0x16, 0x12, 0xd5, 0x83,
-	0x7b, 0x10, 0xc9, 0x49, 0x52, 0xc2, 0x48, 0xc2, 0x30, 0xb3, 0x95, 0x04, 0x9a, 0x2e, 0x3f, 0x99,
-	0xcc, 0xc4, 0xa5, 0xc4, 0xaa, 0x0f, 0x92, 0xb4, 0x62, 0xd4, 0x12, 0x72, 0xe1, 0x62, 0xf3, 0xcb,
-	0x07, 0x9b, 0x23, 0xa6, 0x07, 0xf1, 0xb0, 0x1e, 0xcc, 0xc3, 0x7a, 0xae, 0x20, 0x0f, 0x63, 0x37,
-	0x88, 0x17, 0x6c, 0x10, 0xbb, 0x10, 0xab, 0x3e, 0x48, 0x22, 0x89, 0x0d, 0xac, 0xc7, 0x18, 0x10,
-	0x00, 0x00, 0xff, 0xff, 0x50, 0xad, 0xd0, 0xd8, 0x6c, 0x01, 0x00, 0x00,
+	// 421 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x52, 0x5b, 0x6e, 0xd3, 0x40,
+	0x14, 0xd5, 0xd4, 0x75, 0x1e, 0x37, 0xb4, 0x6a, 0x6f, 0xa1, 0xb2, 0xdc, 0x7e, 0x58, 0x23, 0x15,
+	0x59, 0x20, 0xd9, 0x55, 0xf8, 0x83, 0x9f, 0x20, 0x82, 0x04, 0x3f, 0x28, 0x72, 0x56, 0x30, 0x49,
+	0x06, 0xcb, 0xc2, 0x9e, 0x19, 0xe2, 0x31, 0x92, 0x7f, 0xd9, 0x02, 0x2b, 0x60, 0x4d, 0x6c, 0x81,
+	0x85, 0xa0, 0xf1, 0xd8, 0x79, 0x28, 0x01, 0x94, 0xbf, 0x7b, 0xee, 0x3d, 0x73, 0x1f, 0xe7, 0x0c,
+	0x5c, 0xaa, 0x4c, 0xa4, 0x4a, 0x8a, 0x34, 0x52, 0x6b, 0xa9, 0x25, 0x0e, 0x3a, 0xec, 0xdf, 0xa7,
+	0x52, 0xa6, 0x39, 0x8f, 0x99, 0xca, 0x62, 0x26, 0x84, 0xd4, 0x4c, 0x67, 0x52, 0x94, 0x96, 0xe7,
+	0xdf, 0xb5, 0xd5, 0x06, 0x2d, 0xaa, 0xcf, 0x31, 0x2f, 0x94, 0xae, 0x6d, 0x91, 0x3e, 0xc0, 0x68,
+	0x96, 0x89, 0x34, 0xe1, 0x5f, 0x2b, 0x5e, 0x6a, 0xbc, 0x85, 0x5e, 0xc9, 0xc5, 0x8a, 0xaf, 0x3d,
+	0x12, 0x90, 0x70, 0x98, 0xb4, 0x88, 0xbe, 0x85, 0x6b, 0x43, 0x9b, 0xeb, 0x35, 0x67, 0xc5, 0x7f,
+	0xc8, 0xf8, 0x14, 0xdc, 0xa5, 0xac, 0x84, 0xf6, 0xce, 0x02, 0x12, 0xba, 0x89, 0x05, 0xf4, 0x01,
+	0x86, 0x33, 0x69, 0x26, 0xa9, 0xbc, 0x46, 0x0f, 0xfa, 0x05, 0x2f, 0x4b, 0x96, 0xf2, 0xf6, 0x6d,
+	0x07, 0xe9, 0x73, 0x80, 0x0d, 0x6d, 0xfc, 0x0f, 0xde, 0x02, 0xfa, 0x33, 0x56, 0xe7, 0x92, 0xad,
+	0xfe, 0xba, 0x07, 0xc2, 0xf9, 0x8a, 0x69, 0xd6, 0xac, 0xf1, 0x24, 0x69, 0x62, 0x7c, 0x09, 0x6e,
+	0xa6, 0x79, 0x51, 0x7a, 0x4e, 0xe0, 0x84, 0xa3, 0xf1, 0xb3, 0x68, 0x23, 0x6a, 0xdb, 0xed, 0xa3,
+	0xe6, 0x45, 0x62, 0x39, 0x74, 0x0e, 0xa3, 0x9d, 0x2c, 0x5e, 0x81, 0xf3, 0x85, 0xd7, 0xed, 0x10,
+	0x13, 0x9a, 0x4b, 0xbf, 0xb1, 0xbc, 0xe2, 0xed, 0x08, 0x0b, 0xf0, 0x1e, 0x86, 0x3a, 0x2b, 0x78,
+	0xa9, 0x59, 0xa1, 0x3c, 0x27, 0x20, 0xa1, 0x93, 0x6c, 0x13, 0xe3, 0x9f, 0x0e, 0x0c, 0x8c, 0x96,
+	0xe6, 0x4a, 0x9c, 0xc2, 0xb9, 0x89, 0x71, 0x77, 0x8f, 0xad, 0x1d, 0xfe, 0xcd, 0x4e, 0xba, 0x13,
+	0x85, 0x5e, 0x7d, 0xff, 0xf5, 0xfb, 0xc7, 0x19, 0xbc, 0x26, 0x2f, 0xa8, 0x1b, 0x9b, 0x3a, 0x4e,
+	0xa1, 0xf7, 0x49, 0x36, 0x7d, 0x6e, 0x23, 0x6b, 0x76, 0xd4, 0x99, 0x1d, 0xbd, 0x37, 0x66, 0x1f,
+	0x6f, 0x74, 0xd1, 0x34, 0xea, 0xa3, 0x1b, 0x9b, 0x02, 0x4e, 0x00, 0xb6, 0x1e, 0xe3, 0xdd, 0xfe,
+	0x46, 0x7b, 0xce, 0x1f, 0x6d, 0xf7, 0x48, 0xf0, 0x8d, 0xfd, 0x4c, 0xef, 0x64, 0x9e, 0xf3, 0xa5,
+	0x3e, 0xe5, 0xa8, 0x90, 0xe0, 0x04, 0x2e, 0x3b, 0x59, 0xda, 0x15, 0x4e, 0x7a, 0xff, 0x48, 0xf0,
+	0x83, 0x1d, 0xdf, 0x7d, 0x8b, 0xeb, 0x03, 0x6f, 0xfd, 0xc3, 0x14, 0xbd, 0x69, 0x44, 0xb8, 0x30,
+	0x6a, 0x0e, 0x62, 0x65, 0x93, 0x8b, 0x5e, 0x23, 0xdf, 0xab, 0x3f, 0x03, 0x00, 0xc0, 0xad, 0x13,
+	0x7d, 0x73, 0x03, 0x00, 0x00,
 }
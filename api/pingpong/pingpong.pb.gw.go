@@ -53,6 +53,21 @@ func request_PingPong_NoPing_0(ctx context.Context, marshaler runtime.Marshaler,
 
 }
 
+func request_PingPong_PingPayload_0(ctx context.Context, marshaler runtime.Marshaler, client PingPongClient, req *http.Request, pathParams map[string]string) (proto.Message, runtime.ServerMetadata, error) {
+	var protoReq Payload
+	var metadata runtime.ServerMetadata
+
+	if req.ContentLength > 0 {
+		if err := marshaler.NewDecoder(req.Body).Decode(&protoReq); err != nil {
+			return nil, metadata, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+
+	msg, err := client.PingPayload(ctx, &protoReq, grpc.Header(&metadata.HeaderMD), grpc.Trailer(&metadata.TrailerMD))
+	return msg, metadata, err
+
+}
+
 // RegisterPingPongHandlerFromEndpoint is same as RegisterPingPongHandler but
 // automatically dials to "endpoint" and closes the connection when "ctx" gets done.
 func RegisterPingPongHandlerFromEndpoint(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) (err error) {
@@ -149,6 +164,35 @@ func RegisterPingPongHandlerClient(ctx context.Context, mux *runtime.ServeMux, c
 
 	})
 
+	mux.Handle("POST", pattern_PingPong_PingPayload_0, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		if cn, ok := w.(http.CloseNotifier); ok {
+			go func(done <-chan struct{}, closed <-chan bool) {
+				select {
+				case <-done:
+				case <-closed:
+					cancel()
+				}
+			}(ctx.Done(), cn.CloseNotify())
+		}
+		inboundMarshaler, outboundMarshaler := runtime.MarshalerForRequest(mux, req)
+		rctx, err := runtime.AnnotateContext(ctx, mux, req)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+			return
+		}
+		resp, md, err := request_PingPong_PingPayload_0(rctx, inboundMarshaler, client, req, pathParams)
+		ctx = runtime.NewServerMetadataContext(ctx, md)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+			return
+		}
+
+		forward_PingPong_PingPayload_0(ctx, mux, outboundMarshaler, w, req, resp, mux.GetForwardResponseOptions()...)
+
+	})
+
 	return nil
 }
 
@@ -156,10 +200,14 @@ var (
 	pattern_PingPong_Ping_0 = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0}, []string{"ping"}, ""))
 
 	pattern_PingPong_NoPing_0 = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0}, []string{"pong"}, ""))
+
+	pattern_PingPong_PingPayload_0 = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0}, []string{"payload"}, ""))
 )
 
 var (
 	forward_PingPong_Ping_0 = runtime.ForwardResponseMessage
 
 	forward_PingPong_NoPing_0 = runtime.ForwardResponseMessage
+
+	forward_PingPong_PingPayload_0 = runtime.ForwardResponseMessage
 )
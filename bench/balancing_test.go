@@ -0,0 +1,51 @@
+package bench
+
+import (
+	"flag"
+	"net/http"
+	"testing"
+	"time"
+
+	server "github.com/chris-rock/gyrpsy/gateway/go/gateway"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+var backendCount = flag.Int("backends", 3, "number of pp_grpc instances of the balanced benchmarks")
+var slowBackend = flag.Duration("slowbackend", 2*time.Millisecond, "extra latency of one pp_grpc instance of the balanced benchmarks, 0 disables it")
+
+// balancers are compared by the balanced benchmarks
+var balancers = []string{server.RoundRobin, server.LeastRequest}
+
+// delayInterceptor slows down every call of a backend
+func delayInterceptor(delay time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		time.Sleep(delay)
+		return handler(ctx, req)
+	}
+}
+
+// backendRest uses the balanced connection to the backends of the gateway
+func backendRest(opts *server.Options) (*http.ServeMux, error) {
+	return pingpongRoute(opts.Backend)
+}
+
+// benchmarkBalanced runs the parallel REST benchmark with every balancer.
+// With a slow backend, round robin waits for it on every nth request, least
+// request sends it fewer requests under load.
+func benchmarkBalanced(b *testing.B, endpoints map[string]*endpoint, path string) {
+	for _, lb := range balancers {
+		b.Run(lb, func(b *testing.B) {
+			e := endpoints[lb]
+			benchmarkParallelRest(b, newHTTPPool(b, e, true), e.url(path))
+		})
+	}
+}
+
+func BenchmarkBalancedGoGatewayRestParallel(b *testing.B) {
+	benchmarkBalanced(b, env(b).balancedGoGateway, "/pingpong/ping")
+}
+
+func BenchmarkBalancedDirectRestParallel(b *testing.B) {
+	benchmarkBalanced(b, env(b).balancedDirectRest, "/ping")
+}
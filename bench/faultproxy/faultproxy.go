@@ -0,0 +1,337 @@
+// Package faultproxy is a TCP proxy that degrades the connections between a
+// client and a server. It works on the byte stream and thus for any protocol
+// on top of TCP, like TLS, HTTP/2 or GRPC, without tc or netem.
+package faultproxy
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// segmentSize is the size of a TCP segment on the wire. Stalls and resets are
+// rolled per segment, like packet loss.
+const segmentSize = 1460
+
+// chunkSize is the largest block that is read at once
+const chunkSize = 32 * 1024
+
+// Faults configures the degradation. It applies to both directions of every
+// connection.
+type Faults struct {
+	// Latency delays every segment
+	Latency time.Duration
+	// Jitter varies the latency uniformly by up to +/- Jitter, without
+	// reordering the stream
+	Jitter time.Duration
+	// Bandwidth limits the throughput in bytes per second, 0 is unlimited
+	Bandwidth int
+	// StallProbability is the chance of a segment to be lost. The lost segment
+	// stalls the stream for StallDuration, like a retransmission timeout.
+	StallProbability float64
+	StallDuration    time.Duration
+	// ResetProbability is the chance of a segment to reset the connection
+	ResetProbability float64
+}
+
+// ParseFaults parses a comma separated list of faults, eg.
+// latency=2ms,jitter=1ms,bandwidth=1250000,stall=0.01,stallduration=200ms,reset=0.001
+func ParseFaults(s string) (Faults, error) {
+	var f Faults
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return f, errors.Errorf("invalid fault %q, expected key=value", kv)
+		}
+
+		var err error
+		switch key, value := parts[0], parts[1]; key {
+		case "latency":
+			f.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			f.Jitter, err = time.ParseDuration(value)
+		case "bandwidth":
+			f.Bandwidth, err = strconv.Atoi(value)
+		case "stall":
+			f.StallProbability, err = strconv.ParseFloat(value, 64)
+		case "stallduration":
+			f.StallDuration, err = time.ParseDuration(value)
+		case "reset":
+			f.ResetProbability, err = strconv.ParseFloat(value, 64)
+		default:
+			return f, errors.Errorf("unknown fault %q", key)
+		}
+		if err != nil {
+			return f, errors.Wrapf(err, "invalid fault %q", kv)
+		}
+	}
+	if f.StallProbability > 0 && f.StallDuration == 0 {
+		f.StallDuration = 200 * time.Millisecond
+	}
+	return f, nil
+}
+
+// Stats counts the faults that were injected
+type Stats struct {
+	Connections int64
+	Stalls      int64
+	Resets      int64
+}
+
+// Proxy forwards the connections of a local port to the target
+type Proxy struct {
+	target   string
+	listener net.Listener
+	faults   atomic.Value
+
+	connections int64
+	stalls      int64
+	resets      int64
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// New starts a proxy on an ephemeral port of localhost
+func New(target string, faults Faults) (*Proxy, error) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "listen")
+	}
+	p := &Proxy{
+		target:   target,
+		listener: lis,
+		conns:    map[net.Conn]struct{}{},
+	}
+	p.faults.Store(faults)
+
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// Addr returns the address the proxy listens on
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Faults returns the current faults
+func (p *Proxy) Faults() Faults {
+	return p.faults.Load().(Faults)
+}
+
+// SetFaults changes the faults, it applies to established connections
+func (p *Proxy) SetFaults(faults Faults) {
+	p.faults.Store(faults)
+}
+
+// Stats returns the number of injected faults
+func (p *Proxy) Stats() Stats {
+	return Stats{
+		Connections: atomic.LoadInt64(&p.connections),
+		Stalls:      atomic.LoadInt64(&p.stalls),
+		Resets:      atomic.LoadInt64(&p.resets),
+	}
+}
+
+// Close stops the proxy and closes all connections
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) serve() {
+	defer p.wg.Done()
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.wg.Add(1)
+		go p.handle(client)
+	}
+}
+
+// track registers the connection for Close, it returns false if the proxy
+// is closed already
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	delete(p.conns, conn)
+	p.mu.Unlock()
+}
+
+func (p *Proxy) handle(client net.Conn) {
+	defer p.wg.Done()
+	atomic.AddInt64(&p.connections, 1)
+
+	server, err := net.Dial("tcp", p.target)
+	if err != nil {
+		client.Close()
+		return
+	}
+	if !p.track(client) || !p.track(server) {
+		client.Close()
+		server.Close()
+		return
+	}
+	defer p.untrack(client)
+	defer p.untrack(server)
+
+	var once sync.Once
+	reset := func() {
+		once.Do(func() {
+			atomic.AddInt64(&p.resets, 1)
+			abort(client)
+			abort(server)
+		})
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		p.pipe(server, client, reset)
+	}()
+	go func() {
+		defer wg.Done()
+		p.pipe(client, server, reset)
+	}()
+	wg.Wait()
+	client.Close()
+	server.Close()
+}
+
+// abort closes the connection with a RST instead of a FIN
+func abort(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+// pipe copies src to dst with the faults. The reader stamps every chunk with
+// its delivery time, the writer delivers the chunks in order.
+func (p *Proxy) pipe(dst, src net.Conn, reset func()) {
+	// the queue bounds the data in flight, a slow writer blocks the reader
+	// and thus the sender via TCP flow control
+	queue := make(chan chunk, 64)
+
+	go func() {
+		defer close(queue)
+		var last time.Time
+		for {
+			buf := make([]byte, chunkSize)
+			n, err := src.Read(buf)
+			if n > 0 {
+				due := time.Now().Add(delay(p.Faults()))
+				// jitter must not reorder the stream
+				if due.Before(last) {
+					due = last
+				}
+				last = due
+				queue <- chunk{data: buf[:n], due: due}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var sendAt time.Time
+	for c := range queue {
+		faults := p.Faults()
+		if d := time.Until(c.due); d > 0 {
+			time.Sleep(d)
+		}
+
+		var stall time.Duration
+		for i := 0; i < len(c.data); i += segmentSize {
+			if faults.ResetProbability > 0 && rand.Float64() < faults.ResetProbability {
+				reset()
+				drain(queue)
+				return
+			}
+			if faults.StallProbability > 0 && rand.Float64() < faults.StallProbability {
+				atomic.AddInt64(&p.stalls, 1)
+				stall += faults.StallDuration
+			}
+		}
+		time.Sleep(stall)
+
+		if faults.Bandwidth > 0 {
+			// the chunk leaves the link after the previous one is transmitted
+			now := time.Now()
+			if sendAt.Before(now) {
+				sendAt = now
+			}
+			sendAt = sendAt.Add(time.Duration(len(c.data)) * time.Second / time.Duration(faults.Bandwidth))
+			time.Sleep(time.Until(sendAt))
+		}
+
+		if _, err := dst.Write(c.data); err != nil {
+			src.Close()
+			drain(queue)
+			return
+		}
+	}
+
+	// forward the half-close of the sender
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// drain unblocks the reader until it notices the closed connection
+func drain(queue chan chunk) {
+	for range queue {
+	}
+}
+
+// delay returns the latency of a segment including the jitter
+func delay(f Faults) time.Duration {
+	d := f.Latency
+	if f.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(2*f.Jitter+1))) - f.Jitter
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
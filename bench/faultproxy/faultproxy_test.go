@@ -0,0 +1,157 @@
+package faultproxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// startEcho runs a TCP server that echos everything
+func startEcho(t *testing.T) net.Listener {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("could not listen %v", err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return lis
+}
+
+// roundTrip sends data through the proxy and reads the echo
+func roundTrip(t *testing.T, p *Proxy, data []byte) ([]byte, error) {
+	conn, err := net.Dial("tcp", p.Addr())
+	if err != nil {
+		t.Fatalf("could not dial %v", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		conn.Write(data)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	return ioutil.ReadAll(conn)
+}
+
+func TestParseFaults(t *testing.T) {
+	f, err := ParseFaults("latency=2ms, jitter=1ms,bandwidth=1250000,stall=0.01,reset=0.001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Faults{
+		Latency:          2 * time.Millisecond,
+		Jitter:           time.Millisecond,
+		Bandwidth:        1250000,
+		StallProbability: 0.01,
+		StallDuration:    200 * time.Millisecond,
+		ResetProbability: 0.001,
+	}
+	if f != expected {
+		t.Errorf("expected %+v, got %+v", expected, f)
+	}
+
+	for _, s := range []string{"latency", "latency=fast", "loss=0.1"} {
+		if _, err := ParseFaults(s); err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+	echo := startEcho(t)
+	defer echo.Close()
+	p, err := New(echo.Addr().String(), Faults{Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	data := bytes.Repeat([]byte("ping"), 100000)
+	start := time.Now()
+	got, err := roundTrip(t, p, data)
+	if err != nil {
+		t.Fatalf("could not read echo %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("expected the echo in order, got %d bytes", len(got))
+	}
+	// both directions are delayed
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected a delay of at least 30ms, got %v", elapsed)
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	echo := startEcho(t)
+	defer echo.Close()
+	p, err := New(echo.Addr().String(), Faults{Bandwidth: 1000 * 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// 100KB at 1MB/s in each direction
+	start := time.Now()
+	if _, err := roundTrip(t, p, make([]byte, 100*1000)); err != nil {
+		t.Fatalf("could not read echo %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected a transfer time of at least 100ms, got %v", elapsed)
+	}
+}
+
+func TestReset(t *testing.T) {
+	echo := startEcho(t)
+	defer echo.Close()
+	p, err := New(echo.Addr().String(), Faults{ResetProbability: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if _, err := roundTrip(t, p, []byte("ping")); err == nil {
+		t.Errorf("expected the connection to be reset")
+	}
+	if stats := p.Stats(); stats.Connections != 1 || stats.Resets != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// faults apply to new segments immediately
+	p.SetFaults(Faults{})
+	if got, err := roundTrip(t, p, []byte("ping")); err != nil || string(got) != "ping" {
+		t.Errorf("expected echo, got %q %v", got, err)
+	}
+}
+
+func TestStall(t *testing.T) {
+	echo := startEcho(t)
+	defer echo.Close()
+	p, err := New(echo.Addr().String(), Faults{StallProbability: 1, StallDuration: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	start := time.Now()
+	if _, err := roundTrip(t, p, []byte("ping")); err != nil {
+		t.Fatalf("could not read echo %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected a stall in both directions, got %v", elapsed)
+	}
+	if stats := p.Stats(); stats.Stalls != 2 {
+		t.Errorf("expected 2 stalls, got %+v", stats)
+	}
+}
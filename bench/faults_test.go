@@ -0,0 +1,141 @@
+package bench
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/chris-rock/gyrpsy/bench/faultproxy"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+var faultProfiles = flag.String("faults", "latency=1ms,jitter=500us;bandwidth=125000;stall=0.01,stallduration=200ms;reset=0.001",
+	"semicolon separated fault profiles of the degraded benchmarks, eg. latency=2ms,jitter=1ms;reset=0.01")
+
+// requestTimeout bounds a request on the degraded network
+const requestTimeout = 10 * time.Second
+
+type faultProfile struct {
+	name   string
+	faults faultproxy.Faults
+}
+
+// parseFaultProfiles parses the -faults flag
+func parseFaultProfiles(b *testing.B) []faultProfile {
+	var profiles []faultProfile
+	for _, s := range strings.Split(*faultProfiles, ";") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		faults, err := faultproxy.ParseFaults(s)
+		if err != nil {
+			b.Fatalf("invalid fault profile %v", err)
+		}
+		profiles = append(profiles, faultProfile{name: s, faults: faults})
+	}
+	return profiles
+}
+
+// runDegraded runs the call with every fault profile. Failed calls do not
+// stop the benchmark, they are reported as errors/op.
+func runDegraded(b *testing.B, call func() error) {
+	h := env(b)
+	// warm up the connection on the healthy network
+	if err := call(); err != nil {
+		b.Fatalf("could not call %v", err)
+	}
+
+	for _, profile := range parseFaultProfiles(b) {
+		b.Run(profile.name, func(b *testing.B) {
+			h.degrade(profile.faults)
+			defer h.degrade(faultproxy.Faults{})
+
+			hist := newHistogram()
+			var errs int
+			for n := 0; n < b.N; n++ {
+				start := time.Now()
+				if err := call(); err != nil {
+					errs++
+					continue
+				}
+				hist.since(start)
+			}
+			hist.report(b)
+			b.ReportMetric(float64(errs)/float64(b.N), "errors/op")
+		})
+	}
+}
+
+func benchmarkDegradedGrpc(b *testing.B, e *endpoint) {
+	// wait for the reconnect after a reset instead of failing
+	conn := e.dial(b, grpc.WithDefaultCallOptions(grpc.FailFast(false)))
+	defer conn.Close()
+	client := pingpong.NewPingPongClient(conn)
+
+	runDegraded(b, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		output, err := client.Ping(ctx, &pingpong.PingRequest{Sender: "John"})
+		res = output
+		return err
+	})
+}
+
+func benchmarkDegradedRest(b *testing.B, e *endpoint, path string) {
+	httpClient := &http.Client{Transport: e.transport(b, false), Timeout: requestTimeout}
+	u := e.url(path)
+	var body = []byte(`{ "sender": "John"}`)
+
+	runDegraded(b, func() error {
+		req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+
+		res, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		reqdata, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			return errors.Errorf("could not send message %d\n%v\n%v", res.StatusCode, res.Status, string(reqdata))
+		}
+		return nil
+	})
+}
+
+func BenchmarkDegradedGoGatewayGRPC(b *testing.B) {
+	benchmarkDegradedGrpc(b, env(b).faultyGoGateway)
+}
+
+func BenchmarkDegradedDirectGrpc(b *testing.B) {
+	benchmarkDegradedGrpc(b, env(b).faultyDirectGrpc)
+}
+
+func BenchmarkDegradedNginxTLSGrpc(b *testing.B) {
+	benchmarkDegradedGrpc(b, env(b).faultyNginxTLS)
+}
+
+func BenchmarkDegradedGoGatewayRest(b *testing.B) {
+	benchmarkDegradedRest(b, env(b).faultyGoGateway, "/pingpong/ping")
+}
+
+// BenchmarkDegradedDirectRest degrades both hops, from the client to pp_rest
+// and from pp_rest to pp_grpc
+func BenchmarkDegradedDirectRest(b *testing.B) {
+	benchmarkDegradedRest(b, env(b).faultyDirectRest, "/ping")
+}
+
+func BenchmarkDegradedNginxRestHTTPS(b *testing.B) {
+	benchmarkDegradedRest(b, env(b).faultyNginxTLS, "/ping")
+}
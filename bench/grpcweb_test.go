@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/golang/protobuf/proto"
+)
+
+// BenchmarkGoGatewayGrpcWeb calls PingPong like a browser via grpc-web over
+// HTTP/1.1, next to the JSON gateway
+func BenchmarkGoGatewayGrpcWeb(b *testing.B) {
+	e := env(b).goGateway
+	// HTTP/1.1 like most grpc-web clients in browsers
+	httpClient := &http.Client{Transport: e.transport(b, false)}
+	u := e.url("/pingpong.PingPong/Ping")
+
+	msg, err := proto.Marshal(&pingpong.PingRequest{Sender: "John"})
+	if err != nil {
+		b.Fatalf("could not marshal %v", err)
+	}
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+
+	b.Run("pingpong grpc-web ingestion", func(b *testing.B) {
+		h := newHistogram()
+		for n := 0; n < b.N; n++ {
+			start := time.Now()
+			req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+			if err != nil {
+				b.Fatalf("Error %s", err)
+			}
+			req.Header.Set("Content-Type", "application/grpc-web+proto")
+			req.Header.Set("X-Grpc-Web", "1")
+
+			res, err := httpClient.Do(req)
+			if err != nil {
+				b.Fatalf("could not do http call %v", err)
+			}
+			reqdata, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != 200 || res.Header.Get("Grpc-Status") != "" && res.Header.Get("Grpc-Status") != "0" {
+				b.Fatalf("could not send message %d\n%v\n%v", res.StatusCode, res.Status, string(reqdata))
+			}
+			h.since(start)
+		}
+		h.report(b)
+	})
+}
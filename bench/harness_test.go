@@ -0,0 +1,781 @@
+package bench
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/chris-rock/gyrpsy/bench/faultproxy"
+	server "github.com/chris-rock/gyrpsy/gateway/go/gateway"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// endpoint is a service of the benchmark setup
+type endpoint struct {
+	addr string
+	// roots trusts the self-signed certificate, it is nil for plaintext
+	roots *x509.CertPool
+	// skip explains why the endpoint is not available
+	skip string
+}
+
+// check skips the benchmark if the endpoint is not available
+func (e *endpoint) check(b *testing.B) {
+	b.Helper()
+	if e.skip != "" {
+		b.Skip(e.skip)
+	}
+}
+
+// dial connects a GRPC client to the endpoint
+func (e *endpoint) dial(b *testing.B, opts ...grpc.DialOption) *grpc.ClientConn {
+	e.check(b)
+	conn, err := grpc.Dial(e.addr, append(e.dialOptions(), opts...)...)
+	if err != nil {
+		b.Fatalf("could not dial %v", err)
+	}
+	return conn
+}
+
+func (e *endpoint) dialOptions() []grpc.DialOption {
+	if e.roots == nil {
+		return []grpc.DialOption{grpc.WithInsecure()}
+	}
+	return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(e.tlsConfig()))}
+}
+
+func (e *endpoint) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName: "localhost",
+		RootCAs:    e.roots,
+	}
+}
+
+func (e *endpoint) port() string {
+	_, port, _ := net.SplitHostPort(e.addr)
+	return port
+}
+
+// url returns the REST url of the path
+func (e *endpoint) url(path string) string {
+	if e.roots == nil {
+		return fmt.Sprintf("http://%s%s", e.addr, path)
+	}
+	return fmt.Sprintf("https://%s%s", e.addr, path)
+}
+
+// transport creates a http transport for the endpoint. TLS endpoints use
+// HTTP/2 if h2 is set and HTTP/1.1 with keep-alive otherwise.
+func (e *endpoint) transport(b *testing.B, h2 bool) *http.Transport {
+	e.check(b)
+	transport := &http.Transport{MaxIdleConnsPerHost: 1024}
+	if e.roots == nil {
+		return transport
+	}
+	transport.TLSClientConfig = e.tlsConfig()
+	if h2 {
+		if err := http2.ConfigureTransport(transport); err != nil {
+			b.Fatalf("could not configure http2 %v", err)
+		}
+	} else {
+		// an empty map disables the automatic HTTP/2 upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// harness runs all services of the benchmark setup on ephemeral ports with
+// freshly generated certificates. It replaces make start/go-gateway,
+// start/go-gateway-h2c, start/service-grpc, start/service-rest and nginx.
+type harness struct {
+	goGateway         *endpoint // Go gateway with TLS, GRPC and REST on one port
+	goGatewayH2C      *endpoint // Go gateway via h2c
+	goGatewayNative   *endpoint // dedicated GRPC port of a Go gateway, served by grpc.Server.Serve
+	goGatewayLoopback *endpoint // Go gateway whose REST routes call its GRPC port via TLS over loopback
+	goGatewayProxy    *endpoint // Go gateway with TLS, proxies to directGrpcH2C like nginxGrpc
+	directGrpc        *endpoint // pp_grpc with TLS
+	directGrpcH2C     *endpoint // pp_grpc without TLS, the nginx backend
+	directRest        *endpoint // pp_rest with TLS
+	directRestHTTP    *endpoint // pp_rest without TLS, the nginx backend
+	nginxTLS          *endpoint // nginx grpc_pass to directGrpc and proxy_pass to directRestHTTP
+	nginxGrpc         *endpoint // nginx grpc_pass to directGrpcH2C
+
+	// the fault proxies degrade the network of the faulty endpoints
+	proxies          []*faultproxy.Proxy
+	faultyGoGateway  *endpoint
+	faultyDirectGrpc *endpoint
+	faultyDirectRest *endpoint // pp_rest calls pp_grpc via a proxy as well
+	faultyNginxTLS   *endpoint
+
+	// the balanced endpoints call a pool of pp_grpc instances, by balancer
+	balancedGoGateway  map[string]*endpoint // Go gateway with Config.Backends
+	balancedDirectRest map[string]*endpoint // pp_rest with -backends
+
+	dir     string
+	closers []func()
+}
+
+var (
+	setupOnce sync.Once
+	setup     *harness
+	setupErr  error
+)
+
+// env starts the harness on first use
+func env(b *testing.B) *harness {
+	setupOnce.Do(func() {
+		setup = &harness{}
+		setupErr = setup.start()
+	})
+	if setupErr != nil {
+		b.Fatalf("could not start benchmark harness %v", setupErr)
+	}
+	return setup
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if setup != nil {
+		setup.stop()
+	}
+	os.Exit(code)
+}
+
+// generateCert creates a self-signed certificate for the hosts, that is valid
+// for server and client authentication
+func generateCert(hosts ...string) (cert []byte, key []byte, err error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"Acme Co"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              hosts,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return cert, key, nil
+}
+
+// freePort asks the kernel for an unused port
+func freePort() (int, error) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port, nil
+}
+
+// newEndpoint reserves a port and generates a certificate for it
+func (h *harness) newEndpoint(plaintext bool) (*endpoint, []byte, []byte, error) {
+	port, err := freePort()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	e := &endpoint{addr: fmt.Sprintf("localhost:%d", port)}
+	if plaintext {
+		return e, nil, nil, nil
+	}
+
+	cert, key, err := generateCert("localhost", e.addr)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	e.roots = x509.NewCertPool()
+	e.roots.AppendCertsFromPEM(cert)
+	return e, cert, key, nil
+}
+
+func (h *harness) start() error {
+	dir, err := ioutil.TempDir("", "gyrpsy-bench")
+	if err != nil {
+		return err
+	}
+	h.dir = dir
+	h.closers = append(h.closers, func() { os.RemoveAll(dir) })
+
+	if err := h.startGateways(); err != nil {
+		h.stop()
+		return errors.Wrap(err, "start go gateway")
+	}
+	if err := h.startGrpc(); err != nil {
+		h.stop()
+		return errors.Wrap(err, "start pp_grpc")
+	}
+	if err := h.startGatewayProxy(); err != nil {
+		h.stop()
+		return errors.Wrap(err, "start go gateway proxy")
+	}
+	if err := h.startRest(); err != nil {
+		h.stop()
+		return errors.Wrap(err, "start pp_rest")
+	}
+	h.startNginx()
+	if err := h.startFaults(); err != nil {
+		h.stop()
+		return errors.Wrap(err, "start fault proxies")
+	}
+	if err := h.startBalanced(); err != nil {
+		h.stop()
+		return errors.Wrap(err, "start balanced backends")
+	}
+	return nil
+}
+
+func (h *harness) stop() {
+	for i := len(h.closers) - 1; i >= 0; i-- {
+		h.closers[i]()
+	}
+	h.closers = nil
+}
+
+// startGateways runs the Go gateway like gateway/go/main.go, with TLS and
+// h2c, and the variants of the TLS gateway the in-process benchmarks compare
+func (h *harness) startGateways() error {
+	var err error
+	if h.goGateway, err = h.startGoGateway(false, false, inProcessRest); err != nil {
+		return err
+	}
+	if h.goGatewayH2C, err = h.startGoGateway(true, false, inProcessRest); err != nil {
+		return err
+	}
+	if h.goGatewayNative, err = h.startGoGateway(false, true, inProcessRest); err != nil {
+		return err
+	}
+	h.goGatewayLoopback, err = h.startGoGateway(false, false, loopbackRest)
+	return err
+}
+
+// startGateway runs a Go gateway on a new endpoint. register adds the
+// services and routes before it serves. A failure of Serve, eg. a port
+// conflict, fails the setup right away instead of the benchmarks.
+func (h *harness) startGateway(config server.Config, register func(*server.Server) error) (*endpoint, *server.Server, error) {
+	e, cert, key, err := h.newEndpoint(config.Plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.Hostname = "localhost"
+	config.Port, _ = strconv.Atoi(e.port())
+	config.Key = key
+	config.Cert = cert
+
+	s, err := server.NewServer(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := register(s); err != nil {
+		return nil, nil, err
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve()
+	}()
+	h.closers = append(h.closers, func() { s.Shutdown(context.Background()) })
+	listening := make(chan error, 1)
+	go func() {
+		listening <- waitForPort(e.addr)
+	}()
+	select {
+	case err := <-served:
+		if err == nil {
+			err = errors.New("stopped")
+		}
+		return nil, nil, errors.Wrapf(err, "serve gateway on %s", e.addr)
+	case err := <-listening:
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return e, s, nil
+}
+
+// startGoGateway runs a Go gateway with the pingpong service and its REST
+// routes at /pingpong/. With native set, GRPC is served on a dedicated port
+// by grpc.Server.Serve instead of the ServeHTTP bridge, and the returned
+// endpoint is the GRPC port.
+func (h *harness) startGoGateway(plaintext, native bool, rest func(*server.Options) (*http.ServeMux, error)) (*endpoint, error) {
+	config := server.Config{
+		Plaintext: plaintext,
+		GrpcWeb:   true,
+		// the payload benchmarks send up to maxMsgSize in both directions
+		MaxRecvMsgSize: maxMsgSize,
+		MaxSendMsgSize: maxMsgSize,
+	}
+	if native {
+		var err error
+		if config.GrpcPort, err = freePort(); err != nil {
+			return nil, err
+		}
+	}
+
+	e, s, err := h.startGateway(config, func(s *server.Server) error {
+		err := s.HandleGRPC(func(opts *server.Options, grpcServer *grpc.Server) error {
+			pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return s.HandleGateway("/pingpong/", rest, "pingpong.PingPong")
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the loopback connection may still be in backoff from dialing before
+	// the gateway was listening
+	if err := waitForRest(e); err != nil {
+		return nil, err
+	}
+
+	if native {
+		return &endpoint{addr: s.Options.GrpcAddr, roots: e.roots}, nil
+	}
+	return e, nil
+}
+
+// startGatewayProxy runs the Go gateway without the pingpong service, it
+// forwards the calls as raw frames to pp_grpc
+func (h *harness) startGatewayProxy() error {
+	e, _, err := h.startGateway(server.Config{GrpcProxy: true}, func(s *server.Server) error {
+		return s.HandleProxy("/pingpong.PingPong/", &server.Backends{
+			Addrs: []string{h.directGrpcH2C.addr},
+			Dopts: []grpc.DialOption{grpc.WithInsecure()},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	h.goGatewayProxy = e
+	return nil
+}
+
+// startGrpc runs the pingpong service like services/pp_grpc
+func (h *harness) startGrpc() error {
+	for _, plaintext := range []bool{false, true} {
+		e, cert, key, err := h.newEndpoint(plaintext)
+		if err != nil {
+			return err
+		}
+
+		var opts []grpc.ServerOption
+		if !plaintext {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, grpc.Creds(credentials.NewServerTLSFromCert(&pair)))
+		}
+		grpcServer := grpc.NewServer(opts...)
+		pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
+
+		lis, err := net.Listen("tcp", e.addr)
+		if err != nil {
+			return err
+		}
+		go grpcServer.Serve(lis)
+		h.closers = append(h.closers, grpcServer.Stop)
+
+		if plaintext {
+			h.directGrpcH2C = e
+		} else {
+			h.directGrpc = e
+		}
+	}
+	return nil
+}
+
+// startRest runs the REST gateway like services/pp_rest, it calls directGrpc
+func (h *harness) startRest() error {
+	handler, err := h.restHandler(h.directGrpc)
+	if err != nil {
+		return err
+	}
+	if h.directRest, err = h.serveRest(handler, false); err != nil {
+		return err
+	}
+	h.directRestHTTP, err = h.serveRest(handler, true)
+	return err
+}
+
+// restHandler creates the REST gateway for the GRPC service at backend
+func (h *harness) restHandler(backend *endpoint) (http.Handler, error) {
+	conn, err := grpc.Dial(backend.addr, backend.dialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	h.closers = append(h.closers, func() { conn.Close() })
+
+	gwmux := runtime.NewServeMux()
+	if err := pingpong.RegisterPingPongHandler(context.Background(), gwmux, conn); err != nil {
+		return nil, err
+	}
+	return gwmux, nil
+}
+
+// serveRest serves the REST gateway with TLS or plaintext
+func (h *harness) serveRest(handler http.Handler, plaintext bool) (*endpoint, error) {
+	e, cert, key, err := h.newEndpoint(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: handler}
+	lis, err := net.Listen("tcp", e.addr)
+	if err != nil {
+		return nil, err
+	}
+	if !plaintext {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			lis.Close()
+			return nil, err
+		}
+		srv.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{pair},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
+		lis = tls.NewListener(lis, srv.TLSConfig)
+	}
+	go srv.Serve(lis)
+	h.closers = append(h.closers, func() { srv.Close() })
+	return e, nil
+}
+
+// proxy puts a fault proxy in front of the endpoint
+func (h *harness) proxy(e *endpoint) (*endpoint, error) {
+	if e.skip != "" {
+		return &endpoint{skip: e.skip}, nil
+	}
+	p, err := faultproxy.New(e.addr, faultproxy.Faults{})
+	if err != nil {
+		return nil, err
+	}
+	h.proxies = append(h.proxies, p)
+	h.closers = append(h.closers, func() { p.Close() })
+	return &endpoint{addr: p.Addr(), roots: e.roots}, nil
+}
+
+// startFaults puts fault proxies between the clients and the gateways, and
+// between pp_rest and pp_grpc. The Go gateway calls its GRPC service
+// in-process and nginx uses its own backends, they are degraded on the client
+// side only.
+func (h *harness) startFaults() error {
+	var err error
+	if h.faultyGoGateway, err = h.proxy(h.goGateway); err != nil {
+		return err
+	}
+	if h.faultyDirectGrpc, err = h.proxy(h.directGrpc); err != nil {
+		return err
+	}
+	if h.faultyNginxTLS, err = h.proxy(h.nginxTLS); err != nil {
+		return err
+	}
+
+	backend, err := h.proxy(h.directGrpc)
+	if err != nil {
+		return err
+	}
+	handler, err := h.restHandler(backend)
+	if err != nil {
+		return err
+	}
+	rest, err := h.serveRest(handler, false)
+	if err != nil {
+		return err
+	}
+	h.faultyDirectRest, err = h.proxy(rest)
+	return err
+}
+
+// startBalanced runs a pool of pp_grpc instances. The first one answers
+// -slowbackend slower, like a backend on a busy host. The Go gateway and
+// pp_rest balance across the pool with every balancer.
+func (h *harness) startBalanced() error {
+	cert, key, err := generateCert("localhost")
+	if err != nil {
+		return err
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(cert)
+
+	var addrs []string
+	for i := 0; i < *backendCount; i++ {
+		opts := []grpc.ServerOption{grpc.Creds(credentials.NewServerTLSFromCert(&pair))}
+		if i == 0 && *slowBackend > 0 {
+			opts = append(opts, grpc.UnaryInterceptor(delayInterceptor(*slowBackend)))
+		}
+		grpcServer := grpc.NewServer(opts...)
+		pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
+		healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+
+		lis, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			return err
+		}
+		go grpcServer.Serve(lis)
+		h.closers = append(h.closers, grpcServer.Stop)
+		addrs = append(addrs, lis.Addr().String())
+	}
+
+	h.balancedGoGateway = map[string]*endpoint{}
+	h.balancedDirectRest = map[string]*endpoint{}
+	for _, lb := range balancers {
+		backends := &server.Backends{
+			Addrs:       addrs,
+			Balancer:    lb,
+			HealthCheck: true,
+			Dopts:       []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(roots, "localhost"))},
+		}
+
+		e, _, err := h.startGateway(server.Config{Backends: backends}, func(s *server.Server) error {
+			return s.HandleGateway("/pingpong/", backendRest, "pingpong.PingPong")
+		})
+		if err != nil {
+			return err
+		}
+		h.balancedGoGateway[lb] = e
+
+		conn, err := backends.Dial(nil)
+		if err != nil {
+			return err
+		}
+		h.closers = append(h.closers, func() { conn.Close() })
+		gwmux := runtime.NewServeMux()
+		if err := pingpong.RegisterPingPongHandler(context.Background(), gwmux, conn); err != nil {
+			return err
+		}
+		if h.balancedDirectRest[lb], err = h.serveRest(gwmux, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// degrade sets the faults of all proxies
+func (h *harness) degrade(faults faultproxy.Faults) {
+	for _, p := range h.proxies {
+		p.SetFaults(faults)
+	}
+}
+
+// nginxConf mirrors gateway/nginx/nginx.conf with the ports of the harness
+var nginxConf = template.Must(template.New("nginx").Parse(`
+worker_processes  1;
+daemon            off;
+pid               {{.Dir}}/nginx.pid;
+error_log         {{.Dir}}/error.log warn;
+
+events {
+    worker_connections  1024;
+}
+
+http {
+    access_log             off;
+    client_body_temp_path  {{.Dir}}/body;
+    proxy_temp_path        {{.Dir}}/proxy;
+    fastcgi_temp_path      {{.Dir}}/fastcgi;
+    uwsgi_temp_path        {{.Dir}}/uwsgi;
+    scgi_temp_path         {{.Dir}}/scgi;
+    keepalive_timeout      65;
+
+    server {
+        listen               {{.TLSPort}} ssl http2;
+        server_name          localhost;
+        ssl_certificate      {{.Dir}}/cert_tls.pem;
+        ssl_certificate_key  {{.Dir}}/key_tls.pem;
+
+        location /ping {
+            proxy_pass http://{{.RestHTTP}};
+        }
+
+        location /pingpong.PingPong/ {
+            grpc_pass grpcs://{{.Grpc}};
+        }
+    }
+
+    server {
+        listen               {{.GrpcPort}} ssl http2;
+        server_name          localhost;
+        ssl_certificate      {{.Dir}}/cert_grpc.pem;
+        ssl_certificate_key  {{.Dir}}/key_grpc.pem;
+
+        location /pingpong.PingPong/ {
+            grpc_pass grpc://{{.GrpcH2C}};
+        }
+    }
+}
+`))
+
+// startNginx runs nginx as subprocess. If nginx is not installed or does not
+// start, the nginx benchmarks are skipped.
+func (h *harness) startNginx() {
+	h.nginxTLS = &endpoint{}
+	h.nginxGrpc = &endpoint{}
+
+	skip := func(reason string) {
+		h.nginxTLS.skip = reason
+		h.nginxGrpc.skip = reason
+	}
+
+	bin, err := exec.LookPath("nginx")
+	if err != nil {
+		skip("nginx is not installed, skipping the nginx benchmarks")
+		return
+	}
+	if err := h.runNginx(bin); err != nil {
+		skip(fmt.Sprintf("nginx did not start, skipping the nginx benchmarks: %v", err))
+	}
+}
+
+func (h *harness) runNginx(bin string) error {
+	tlsEndpoint, tlsCert, tlsKey, err := h.newEndpoint(false)
+	if err != nil {
+		return err
+	}
+	grpcEndpoint, grpcCert, grpcKey, err := h.newEndpoint(false)
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{
+		"cert_tls.pem":  tlsCert,
+		"key_tls.pem":   tlsKey,
+		"cert_grpc.pem": grpcCert,
+		"key_grpc.pem":  grpcKey,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(h.dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+
+	var conf strings.Builder
+	err = nginxConf.Execute(&conf, map[string]string{
+		"Dir":      h.dir,
+		"TLSPort":  tlsEndpoint.port(),
+		"GrpcPort": grpcEndpoint.port(),
+		"Grpc":     h.directGrpc.addr,
+		"GrpcH2C":  h.directGrpcH2C.addr,
+		"RestHTTP": h.directRestHTTP.addr,
+	})
+	if err != nil {
+		return err
+	}
+	confFile := filepath.Join(h.dir, "nginx.conf")
+	if err := ioutil.WriteFile(confFile, []byte(conf.String()), 0600); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(bin, "-p", h.dir, "-c", confFile)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	h.closers = append(h.closers, func() {
+		cmd.Process.Kill()
+		<-exited
+	})
+
+	for _, addr := range []string{tlsEndpoint.addr, grpcEndpoint.addr} {
+		if err := waitForPort(addr); err != nil {
+			log, _ := ioutil.ReadFile(filepath.Join(h.dir, "error.log"))
+			return errors.Wrapf(err, "%s", strings.TrimSpace(string(log)))
+		}
+	}
+
+	*h.nginxTLS = *tlsEndpoint
+	*h.nginxGrpc = *grpcEndpoint
+	return nil
+}
+
+// waitForRest blocks until the REST routes of the Go gateway reach its GRPC
+// service
+func waitForRest(e *endpoint) error {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: e.tlsConfig()}}
+	defer client.Transport.(*http.Transport).CloseIdleConnections()
+	var err error
+	for i := 0; i < 50; i++ {
+		var res *http.Response
+		res, err = client.Get(e.url("/pingpong/pong"))
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = errors.Errorf("status %s", res.Status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.Wrapf(err, "wait for the REST routes of %s", e.addr)
+}
+
+// waitForPort blocks until the address accepts connections
+func waitForPort(addr string) error {
+	var err error
+	for i := 0; i < 100; i++ {
+		var conn net.Conn
+		conn, err = net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return errors.Wrapf(err, "wait for %s", addr)
+}
@@ -0,0 +1,245 @@
+package bench
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var histogramDir = flag.String("histograms", "", "directory to write the latency histograms of the benchmarks to")
+
+const (
+	// subBucketBits keeps three significant digits, values below 2^subBucketBits
+	// are recorded exactly
+	subBucketBits = 11
+	subBucketHalf = 1 << (subBucketBits - 1)
+	// maxShift tracks latencies up to about an hour
+	maxShift = 32
+)
+
+// histogram records latencies with a constant relative precision like
+// HdrHistogram. The buckets grow exponentially and are split into linear
+// sub-buckets. Record is safe for concurrent use.
+type histogram struct {
+	counts []int64
+	total  int64
+	sum    int64
+	max    int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, 2*subBucketHalf+maxShift*subBucketHalf)}
+}
+
+// bucketIndex returns the index of the sub-bucket which contains v
+func bucketIndex(v int64) int {
+	if v < 2*subBucketHalf {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits
+	if shift > maxShift {
+		return 2*subBucketHalf + maxShift*subBucketHalf - 1
+	}
+	return 2*subBucketHalf + (shift-1)*subBucketHalf + int(v>>uint(shift)) - subBucketHalf
+}
+
+// bucketValue returns the highest value that is recorded at the index
+func bucketValue(index int) int64 {
+	if index < 2*subBucketHalf {
+		return int64(index)
+	}
+	shift := uint((index-2*subBucketHalf)/subBucketHalf + 1)
+	sub := int64((index-2*subBucketHalf)%subBucketHalf + subBucketHalf)
+	return (sub+1)<<shift - 1
+}
+
+// record adds one latency to the histogram
+func (h *histogram) record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddInt64(&h.counts[bucketIndex(v)], 1)
+	atomic.AddInt64(&h.total, 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			break
+		}
+	}
+}
+
+// since records the latency of a request that started at start
+func (h *histogram) since(start time.Time) {
+	h.record(time.Since(start))
+}
+
+// valueAt returns the latency at the percentile between 0 and 100
+func (h *histogram) valueAt(percentile float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(percentile / 100 * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+	var count int64
+	for i, c := range h.counts {
+		count += c
+		if count >= target {
+			// the highest equivalent value may exceed the recorded maximum
+			if v := bucketValue(i); v < h.max {
+				return time.Duration(v)
+			}
+			return time.Duration(h.max)
+		}
+	}
+	return time.Duration(h.max)
+}
+
+func (h *histogram) mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return float64(h.sum) / float64(h.total)
+}
+
+func (h *histogram) stddev() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	mean := h.mean()
+	var sq float64
+	for i, c := range h.counts {
+		if c > 0 {
+			d := float64(bucketValue(i)) - mean
+			sq += d * d * float64(c)
+		}
+	}
+	return math.Sqrt(sq / float64(h.total))
+}
+
+// report adds the percentiles to the benchmark results and dumps the
+// histogram if -histograms is set
+func (h *histogram) report(b *testing.B) {
+	if h.total == 0 {
+		return
+	}
+	b.ReportMetric(float64(h.valueAt(50)), "p50-ns")
+	b.ReportMetric(float64(h.valueAt(90)), "p90-ns")
+	b.ReportMetric(float64(h.valueAt(99)), "p99-ns")
+	b.ReportMetric(float64(h.valueAt(99.9)), "p999-ns")
+	b.ReportMetric(float64(h.max), "max-ns")
+
+	if *histogramDir == "" {
+		return
+	}
+	if err := h.dumpFile(b.Name()); err != nil {
+		b.Errorf("could not write histogram %v", err)
+	}
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9_.=-]+`)
+
+// dumpFile writes the histogram of the benchmark into the histogram dir. The
+// benchmark is called with growing b.N, the last run overwrites the file.
+func (h *histogram) dumpFile(name string) error {
+	if err := os.MkdirAll(*histogramDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(*histogramDir, strings.Trim(unsafeName.ReplaceAllString(name, "_"), "_")+".hgrm"))
+	if err != nil {
+		return err
+	}
+	if err := h.dump(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// dump writes the percentile distribution in microseconds, in the format of
+// HdrHistogram, which can be plotted with the HdrHistogram plotter
+func (h *histogram) dump(w io.Writer) error {
+	const ticksPerHalfDistance = 5
+	const us = float64(time.Microsecond)
+
+	if _, err := fmt.Fprintf(w, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)"); err != nil {
+		return err
+	}
+	var count int64
+	next := 0
+	line := func(percentile float64, value time.Duration) error {
+		// count the values up to and including value
+		for ; next < len(h.counts) && bucketValue(next) <= int64(value); next++ {
+			count += h.counts[next]
+		}
+		if percentile >= 100 {
+			_, err := fmt.Fprintf(w, "%12.3f %2.12f %10d\n", float64(value)/us, 1.0, h.total)
+			return err
+		}
+		_, err := fmt.Fprintf(w, "%12.3f %2.12f %10d %14.2f\n", float64(value)/us, percentile/100, count, 100/(100-percentile))
+		return err
+	}
+
+	for percentile := 0.0; percentile < 100; {
+		value := h.valueAt(percentile)
+		if value >= time.Duration(h.max) {
+			break
+		}
+		if err := line(percentile, value); err != nil {
+			return err
+		}
+		// the steps halve with every halving of the remaining distance
+		halfDistance := math.Pow(2, math.Floor(math.Log2(100/(100-percentile)))+1)
+		percentile += 100 / (halfDistance * ticksPerHalfDistance)
+	}
+	if err := line(100, time.Duration(h.max)); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n#[Max     = %12.3f, Total count    = %12d]\n#[Buckets = %12d, SubBuckets     = %12d]\n",
+		h.mean()/us, h.stddev()/us, float64(h.max)/us, h.total, maxShift+1, 2*subBucketHalf)
+	return err
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	for v := 1; v <= 10000; v++ {
+		h.record(time.Duration(v) * time.Microsecond)
+	}
+
+	for percentile, expected := range map[float64]time.Duration{
+		50:   5000 * time.Microsecond,
+		90:   9000 * time.Microsecond,
+		99:   9900 * time.Microsecond,
+		99.9: 9990 * time.Microsecond,
+		100:  10000 * time.Microsecond,
+	} {
+		got := h.valueAt(percentile)
+		// three significant digits
+		if math.Abs(float64(got-expected)) > float64(expected)/1000 {
+			t.Errorf("p%v: expected %v, got %v", percentile, expected, got)
+		}
+	}
+	if time.Duration(h.max) != 10000*time.Microsecond {
+		t.Errorf("expected max 10ms, got %v", time.Duration(h.max))
+	}
+
+	// the bucket of a value ends within three significant digits
+	for _, v := range []int64{0, 1, 2047, 2048, 4095, 1 << 40} {
+		if got := bucketValue(bucketIndex(v)); got < v || float64(got-v) > float64(v)/1000 {
+			t.Errorf("bucket of %d ends at %d", v, got)
+		}
+	}
+}
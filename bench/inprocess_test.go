@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	server "github.com/chris-rock/gyrpsy/gateway/go/gateway"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// loopbackRest dials the GRPC endpoint of the gateway over TLS
+func loopbackRest(opts *server.Options) (*http.ServeMux, error) {
+	pingpongMux := runtime.NewServeMux()
+	err := pingpong.RegisterPingPongHandlerFromEndpoint(context.Background(), pingpongMux, opts.GrpcAddr, opts.Dopts)
+	if err != nil {
+		return nil, err
+	}
+	route := http.NewServeMux()
+	route.Handle("/", pingpongMux)
+	return route, nil
+}
+
+// inProcessRest uses the in-process connection of the gateway
+func inProcessRest(opts *server.Options) (*http.ServeMux, error) {
+	return pingpongRoute(opts.Conn)
+}
+
+// pingpongRoute serves the REST gateway of the pingpong service via conn
+func pingpongRoute(conn *grpc.ClientConn) (*http.ServeMux, error) {
+	pingpongMux := runtime.NewServeMux()
+	err := pingpong.RegisterPingPongHandler(context.Background(), pingpongMux, conn)
+	if err != nil {
+		return nil, err
+	}
+	route := http.NewServeMux()
+	route.Handle("/", pingpongMux)
+	return route, nil
+}
+
+// BenchmarkGoGatewayNativeGrpc serves GRPC on a dedicated port via
+// grpc.Server.Serve. BenchmarkGoGatewayGRPC serves it through the
+// http.Handler bridge on the shared port, like the Go gateway does by default.
+func BenchmarkGoGatewayNativeGrpc(b *testing.B) {
+	benchmarkGrpc(b, env(b).goGatewayNative)
+}
+
+// BenchmarkGoGatewayRestLoopback forwards REST calls to the GRPC endpoint of
+// the same process via TLS over loopback. BenchmarkGoGatewayRest forwards
+// them via the in-process connection of the gateway.
+func BenchmarkGoGatewayRestLoopback(b *testing.B) {
+	benchmarkRest(b, env(b).goGatewayLoopback, "/pingpong/ping")
+}
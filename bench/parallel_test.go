@@ -0,0 +1,190 @@
+package bench
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+var concurrency = flag.String("concurrency", "1,16,64", "comma separated list of concurrent requests for the parallel benchmarks")
+var poolSize = flag.Int("pool", 1, "number of connections the parallel benchmarks spread their requests on")
+
+// concurrencyLevels parses the -concurrency flag
+func concurrencyLevels(b *testing.B) []int {
+	var levels []int
+	for _, s := range strings.Split(*concurrency, ",") {
+		level, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || level < 1 {
+			b.Fatalf("invalid concurrency level %q", s)
+		}
+		levels = append(levels, level)
+	}
+	return levels
+}
+
+// runParallel runs the benchmark with every concurrency level. RunParallel
+// starts a multiple of GOMAXPROCS goroutines, therefore the level is rounded
+// up to the next multiple and the name reports the actual concurrency. All
+// goroutines of a level record their latencies into one histogram.
+func runParallel(b *testing.B, name string, body func(pb *testing.PB, h *histogram)) {
+	procs := runtime.GOMAXPROCS(0)
+	for _, level := range concurrencyLevels(b) {
+		parallelism := (level + procs - 1) / procs
+		b.Run(fmt.Sprintf("%s c=%d pool=%d", name, parallelism*procs, *poolSize), func(b *testing.B) {
+			b.SetParallelism(parallelism)
+			h := newHistogram()
+			b.RunParallel(func(pb *testing.PB) {
+				body(pb, h)
+			})
+			h.report(b)
+		})
+	}
+}
+
+// grpcPool spreads the calls round robin on multiple connections
+type grpcPool struct {
+	clients []pingpong.PingPongClient
+	conns   []*grpc.ClientConn
+	next    uint64
+}
+
+func newGrpcPool(b *testing.B, e *endpoint) *grpcPool {
+	p := &grpcPool{}
+	for i := 0; i < *poolSize; i++ {
+		conn := e.dial(b)
+		p.conns = append(p.conns, conn)
+		p.clients = append(p.clients, pingpong.NewPingPongClient(conn))
+	}
+	return p
+}
+
+func (p *grpcPool) client() pingpong.PingPongClient {
+	n := atomic.AddUint64(&p.next, 1)
+	return p.clients[n%uint64(len(p.clients))]
+}
+
+func (p *grpcPool) close() {
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+}
+
+func benchmarkParallelGrpc(b *testing.B, p *grpcPool) {
+	defer p.close()
+
+	runParallel(b, "pingpong proto ingestion", func(pb *testing.PB, h *histogram) {
+		for pb.Next() {
+			start := time.Now()
+			_, err := p.client().Ping(context.Background(), &pingpong.PingRequest{Sender: "John"}, grpc.FailFast(false))
+			if err != nil {
+				// Fatal must not be called outside of the benchmark goroutine
+				b.Errorf("could not call %v", err)
+				return
+			}
+			h.since(start)
+		}
+	})
+}
+
+// httpPool spreads the requests round robin on multiple transports. With
+// HTTP/1.1, every transport keeps one idle connection per concurrent request.
+// With HTTP/2, every transport multiplexes all requests on one connection.
+type httpPool struct {
+	clients []*http.Client
+	next    uint64
+}
+
+func newHTTPPool(b *testing.B, e *endpoint, h2 bool) *httpPool {
+	p := &httpPool{}
+	for i := 0; i < *poolSize; i++ {
+		p.clients = append(p.clients, &http.Client{Transport: e.transport(b, h2)})
+	}
+	return p
+}
+
+func (p *httpPool) client() *http.Client {
+	n := atomic.AddUint64(&p.next, 1)
+	return p.clients[n%uint64(len(p.clients))]
+}
+
+func benchmarkParallelRest(b *testing.B, p *httpPool, u string) {
+	var body = []byte(`{ "sender": "John"}`)
+	runParallel(b, "pingpong json ingestion", func(pb *testing.PB, h *histogram) {
+		for pb.Next() {
+			start := time.Now()
+			req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+			if err != nil {
+				b.Errorf("Error %s", err)
+				return
+			}
+			req.Header.Set("Accept", "application/json")
+
+			res, err := p.client().Do(req)
+			if err != nil {
+				b.Errorf("could not do http call %v", err)
+				return
+			}
+			reqdata, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != 200 {
+				b.Errorf("could not send message %d\n%v\n%v", res.StatusCode, res.Status, string(reqdata))
+				return
+			}
+			h.since(start)
+		}
+	})
+}
+
+func BenchmarkGoGatewayGRPCParallel(b *testing.B) {
+	benchmarkParallelGrpc(b, newGrpcPool(b, env(b).goGateway))
+}
+
+func BenchmarkDirectGrpcParallel(b *testing.B) {
+	benchmarkParallelGrpc(b, newGrpcPool(b, env(b).directGrpc))
+}
+
+func BenchmarkGoGatewayProxyGrpcParallel(b *testing.B) {
+	benchmarkParallelGrpc(b, newGrpcPool(b, env(b).goGatewayProxy))
+}
+
+func BenchmarkNginxTLSGrpcParallel(b *testing.B) {
+	benchmarkParallelGrpc(b, newGrpcPool(b, env(b).nginxTLS))
+}
+
+func BenchmarkGoGatewayRestHTTP1Parallel(b *testing.B) {
+	e := env(b).goGateway
+	benchmarkParallelRest(b, newHTTPPool(b, e, false), e.url("/pingpong/ping"))
+}
+
+func BenchmarkGoGatewayRestHTTP2Parallel(b *testing.B) {
+	e := env(b).goGateway
+	benchmarkParallelRest(b, newHTTPPool(b, e, true), e.url("/pingpong/ping"))
+}
+
+func BenchmarkNginxRestHTTP1Parallel(b *testing.B) {
+	e := env(b).nginxTLS
+	benchmarkParallelRest(b, newHTTPPool(b, e, false), e.url("/ping"))
+}
+
+func BenchmarkNginxRestHTTP2Parallel(b *testing.B) {
+	e := env(b).nginxTLS
+	benchmarkParallelRest(b, newHTTPPool(b, e, true), e.url("/ping"))
+}
+
+// BenchmarkGoGatewayNativeGrpcParallel calls the Go gateway on the dedicated
+// GRPC port
+func BenchmarkGoGatewayNativeGrpcParallel(b *testing.B) {
+	benchmarkParallelGrpc(b, newGrpcPool(b, env(b).goGatewayNative))
+}
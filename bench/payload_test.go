@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/golang/protobuf/jsonpb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// maxMsgSize allows the largest payload of the matrix in both directions
+const maxMsgSize = 16 * 1024 * 1024
+
+// payloadSizes is the matrix of approximate payload sizes in bytes
+var payloadSizes = []struct {
+	name string
+	size int
+}{
+	{"1KB", 1024},
+	{"64KB", 64 * 1024},
+	{"1MB", 1024 * 1024},
+	{"4MB", 4 * 1024 * 1024},
+	{"8MB", 8 * 1024 * 1024},
+}
+
+// newPayload creates a payload of about size bytes. Half of it is raw data,
+// the other half is split into nested items of 1KB each.
+func newPayload(size int) *pingpong.Payload {
+	p := &pingpong.Payload{
+		Sender: "John",
+		Data:   make([]byte, size/2),
+	}
+	for rest := size - size/2; rest > 0; rest -= 1024 {
+		n := 1024
+		if rest < n {
+			n = rest
+		}
+		p.Items = append(p.Items, &pingpong.PayloadItem{
+			Key:       fmt.Sprintf("item-%d", len(p.Items)),
+			Value:     make([]byte, n),
+			Timestamp: int64(len(p.Items)),
+		})
+	}
+	return p
+}
+
+func benchmarkPayloadGrpc(b *testing.B, e *endpoint) {
+	// the client accepts the size limits of the gateways of the harness
+	conn := e.dial(b, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxMsgSize), grpc.MaxCallSendMsgSize(maxMsgSize)))
+	defer conn.Close()
+	client := pingpong.NewPingPongClient(conn)
+
+	for _, ps := range payloadSizes {
+		payload := newPayload(ps.size)
+		b.Run(fmt.Sprintf("pingpong proto payload %s", ps.name), func(b *testing.B) {
+			b.SetBytes(int64(ps.size))
+			h := newHistogram()
+			for n := 0; n < b.N; n++ {
+				start := time.Now()
+				output, err := client.PingPayload(context.Background(), payload)
+				if err != nil {
+					b.Fatalf("could not call %v", err)
+				}
+				if len(output.GetData()) != len(payload.GetData()) {
+					b.Fatalf("unexpected echo of %d bytes", len(output.GetData()))
+				}
+				h.since(start)
+			}
+			h.report(b)
+		})
+	}
+}
+
+// BenchmarkGoGatewayPayloadServeHTTP sends payloads through the http.Handler bridge
+func BenchmarkGoGatewayPayloadServeHTTP(b *testing.B) {
+	benchmarkPayloadGrpc(b, env(b).goGateway)
+}
+
+// BenchmarkGoGatewayPayloadNativeGrpc sends payloads to the dedicated GRPC port
+func BenchmarkGoGatewayPayloadNativeGrpc(b *testing.B) {
+	benchmarkPayloadGrpc(b, env(b).goGatewayNative)
+}
+
+// BenchmarkGoGatewayPayloadRest sends payloads as JSON through the REST
+// gateway, which includes the JSON marshaling on both sides
+func BenchmarkGoGatewayPayloadRest(b *testing.B) {
+	e := env(b).goGateway
+	httpClient := &http.Client{Transport: e.transport(b, false)}
+	u := e.url("/pingpong/payload")
+
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	for _, ps := range payloadSizes {
+		var body bytes.Buffer
+		if err := marshaler.Marshal(&body, newPayload(ps.size)); err != nil {
+			b.Fatalf("could not marshal payload %v", err)
+		}
+
+		b.Run(fmt.Sprintf("pingpong json payload %s", ps.name), func(b *testing.B) {
+			b.SetBytes(int64(ps.size))
+			h := newHistogram()
+			for n := 0; n < b.N; n++ {
+				start := time.Now()
+				req, err := http.NewRequest("POST", u, bytes.NewReader(body.Bytes()))
+				if err != nil {
+					b.Fatalf("Error %s", err)
+				}
+				req.Header.Set("Accept", "application/json")
+
+				res, err := httpClient.Do(req)
+				if err != nil {
+					b.Fatalf("could not do http call %v", err)
+				}
+				reqdata, _ := ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				if res.StatusCode != 200 {
+					b.Fatalf("could not send message %d\n%v\n%v", res.StatusCode, res.Status, string(reqdata))
+				}
+				h.since(start)
+			}
+			h.report(b)
+		})
+	}
+}
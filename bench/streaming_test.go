@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// benchmarkStreaming measures the throughput of the streaming RPCs. Each
+// iteration is a single message within one long-lived stream, therefore the
+// stream setup is amortized and ns/op is the cost per message.
+func benchmarkStreaming(b *testing.B, conn *grpc.ClientConn) {
+	client := pingpong.NewPingPongClient(conn)
+	ping := &pingpong.PingRequest{Sender: "John"}
+
+	b.Run("pingpong server stream", func(b *testing.B) {
+		b.SetBytes(int64(proto.Size(&pingpong.PongReply{Message: "Hello John 0"})))
+		stream, err := client.PingStream(context.Background(), &pingpong.PingStreamRequest{Sender: "John", Count: int32(b.N)})
+		if err != nil {
+			b.Fatalf("could not open stream %v", err)
+		}
+		// the time until each message arrives, the first one includes the
+		// stream setup
+		h := newHistogram()
+		start := time.Now()
+		for n := 0; n < b.N; n++ {
+			output, err := stream.Recv()
+			if err != nil {
+				b.Fatalf("could not receive %v", err)
+			}
+			h.since(start)
+			start = time.Now()
+			res = output
+		}
+		h.report(b)
+		if _, err := stream.Recv(); err != io.EOF {
+			b.Fatalf("expected end of stream, got %v", err)
+		}
+	})
+
+	b.Run("pingpong client stream", func(b *testing.B) {
+		b.SetBytes(int64(proto.Size(ping)))
+		stream, err := client.PingCollect(context.Background())
+		if err != nil {
+			b.Fatalf("could not open stream %v", err)
+		}
+		// the time to hand each message to the stream, it blocks once the
+		// flow control window is full
+		h := newHistogram()
+		for n := 0; n < b.N; n++ {
+			start := time.Now()
+			if err := stream.Send(ping); err != nil {
+				b.Fatalf("could not send %v", err)
+			}
+			h.since(start)
+		}
+		h.report(b)
+		output, err := stream.CloseAndRecv()
+		if err != nil {
+			b.Fatalf("could not close stream %v", err)
+		}
+		res = output
+	})
+
+	b.Run("pingpong bidi stream", func(b *testing.B) {
+		b.SetBytes(int64(proto.Size(ping)))
+		stream, err := client.PingPongStream(context.Background())
+		if err != nil {
+			b.Fatalf("could not open stream %v", err)
+		}
+		// the round trip of one message within the stream
+		h := newHistogram()
+		for n := 0; n < b.N; n++ {
+			start := time.Now()
+			if err := stream.Send(ping); err != nil {
+				b.Fatalf("could not send %v", err)
+			}
+			output, err := stream.Recv()
+			if err != nil {
+				b.Fatalf("could not receive %v", err)
+			}
+			h.since(start)
+			res = output
+		}
+		h.report(b)
+		if err := stream.CloseSend(); err != nil {
+			b.Fatalf("could not close stream %v", err)
+		}
+		if _, err := stream.Recv(); err != io.EOF {
+			b.Fatalf("expected end of stream, got %v", err)
+		}
+	})
+}
+
+func BenchmarkGoGatewayGRPCStreaming(b *testing.B) {
+	conn := env(b).goGateway.dial(b)
+	defer conn.Close()
+	benchmarkStreaming(b, conn)
+}
+
+func BenchmarkDirectGrpcStreaming(b *testing.B) {
+	conn := env(b).directGrpc.dial(b)
+	defer conn.Close()
+	benchmarkStreaming(b, conn)
+}
+
+func BenchmarkNginxTLSGrpcStreaming(b *testing.B) {
+	conn := env(b).nginxTLS.dial(b)
+	defer conn.Close()
+	benchmarkStreaming(b, conn)
+}
+
+func BenchmarkNginxGrpcStreaming(b *testing.B) {
+	conn := env(b).nginxGrpc.dial(b)
+	defer conn.Close()
+	benchmarkStreaming(b, conn)
+}
+
+// BenchmarkGoGatewayNativeGrpcStreaming streams through the dedicated GRPC port
+func BenchmarkGoGatewayNativeGrpcStreaming(b *testing.B) {
+	conn := env(b).goGatewayNative.dial(b)
+	defer conn.Close()
+	benchmarkStreaming(b, conn)
+}
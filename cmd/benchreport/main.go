@@ -0,0 +1,197 @@
+// benchreport runs the bench suite and tracks the results across releases.
+//
+//	benchreport run -count 10 -o results.json -csv results.csv
+//	benchreport table results.json
+//	benchreport compare old.json new.json
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+const usage = `usage: benchreport <command> [flags]
+
+commands:
+  run      run the bench suite and write the results as JSON and CSV
+  table    render the results as markdown table
+  compare  compare two result files as markdown table
+  csv      convert a result file to CSV
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "run":
+		err = run(os.Args[2:])
+	case "table":
+		err = table(os.Args[2:])
+	case "compare":
+		err = compare(os.Args[2:])
+	case "csv":
+		err = convert(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	count := fs.Int("count", 5, "number of runs of every benchmark")
+	bench := fs.String("bench", ".", "benchmarks to run")
+	benchtime := fs.String("benchtime", "1s", "run time of every benchmark")
+	pkg := fs.String("pkg", "./bench", "package of the bench suite")
+	input := fs.String("input", "", "parse the saved output of go test -bench instead of running it")
+	out := fs.String("o", "results.json", "file to write the JSON results to")
+	csvOut := fs.String("csv", "", "file to write the CSV results to")
+	fs.Parse(args)
+
+	results := &Results{Env: environment()}
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return errors.Wrapf(err, "open %s", *input)
+		}
+		defer f.Close()
+		if err := Parse(f, results); err != nil {
+			return err
+		}
+	} else {
+		// additional go test flags follow the flags of benchreport, eg.
+		// benchreport run -count 10 -- -concurrency 1,64
+		goArgs := []string{"test", "-run", "^$", "-bench", *bench, "-benchmem",
+			"-benchtime", *benchtime, "-count", fmt.Sprint(*count), *pkg}
+		goArgs = append(goArgs, fs.Args()...)
+		results.Env.Args = goArgs
+
+		log.Infof("go %s", strings.Join(goArgs, " "))
+		cmd := exec.Command("go", goArgs...)
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return errors.Wrap(err, "start go test")
+		}
+		// show the progress while parsing
+		if err := Parse(io.TeeReader(stdout, os.Stderr), results); err != nil {
+			return err
+		}
+		if err := cmd.Wait(); err != nil {
+			return errors.Wrap(err, "go test failed")
+		}
+	}
+
+	if len(results.Benchmarks) == 0 {
+		return errors.New("no benchmark results found")
+	}
+	if err := results.Save(*out); err != nil {
+		return err
+	}
+	log.Infof("wrote %d benchmarks to %s", len(results.Benchmarks), *out)
+
+	if *csvOut != "" {
+		return writeCSV(results, *csvOut)
+	}
+	return nil
+}
+
+func table(args []string) error {
+	fs := flag.NewFlagSet("table", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: benchreport table results.json")
+	}
+
+	results, err := Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	WriteTable(os.Stdout, results)
+	return nil
+}
+
+func compare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	alpha := fs.Float64("alpha", 0.05, "significance level of the changes")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("usage: benchreport compare old.json new.json")
+	}
+
+	oldResults, err := Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	newResults, err := Load(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	WriteComparison(os.Stdout, oldResults, newResults, *alpha)
+	return nil
+}
+
+func convert(args []string) error {
+	fs := flag.NewFlagSet("csv", flag.ExitOnError)
+	out := fs.String("o", "", "file to write the CSV results to, defaults to stdout")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: benchreport csv [-o results.csv] results.json")
+	}
+
+	results, err := Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return results.WriteCSV(os.Stdout)
+	}
+	return writeCSV(results, *out)
+}
+
+func writeCSV(results *Results, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "create %s", filename)
+	}
+	if err := results.WriteCSV(f); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "write %s", filename)
+	}
+	return f.Close()
+}
+
+// environment collects the metadata of the machine and the revision
+func environment() Environment {
+	env := Environment{
+		Date:      time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+	}
+	env.Hostname, _ = os.Hostname()
+	if commit, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+		env.Commit = strings.TrimSpace(string(commit))
+	}
+	return env
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// formatValue renders latencies as durations and everything else as number
+func formatValue(unit string, v float64) string {
+	switch {
+	case unit == "ns/op" || strings.HasSuffix(unit, "-ns"):
+		return time.Duration(v).Round(durationPrecision(v)).String()
+	case unit == "MB/s":
+		return fmt.Sprintf("%.2f MB/s", v)
+	default:
+		return fmt.Sprintf("%.0f", v)
+	}
+}
+
+// durationPrecision keeps about three significant digits
+func durationPrecision(v float64) time.Duration {
+	p := time.Duration(1)
+	for float64(p)*1000 <= v {
+		p *= 10
+	}
+	return p
+}
+
+// columnName is the header of the unit in the tables
+func columnName(unit string) string {
+	if strings.HasSuffix(unit, "-ns") {
+		return strings.TrimSuffix(unit, "-ns")
+	}
+	return unit
+}
+
+// WriteTable renders the median of every unit as markdown table
+func WriteTable(w io.Writer, results *Results) {
+	var units []string
+	seen := map[string]bool{}
+	for _, b := range results.Benchmarks {
+		for _, unit := range b.Units() {
+			if !seen[unit] {
+				seen[unit] = true
+				units = append(units, unit)
+			}
+		}
+	}
+	sortUnits(units)
+
+	env := results.Env
+	fmt.Fprintf(w, "%s/%s, %s, %s", env.GOOS, env.GOARCH, env.GoVersion, env.CPU)
+	if env.Commit != "" {
+		fmt.Fprintf(w, ", commit %s", shortCommit(env.Commit))
+	}
+	fmt.Fprintf(w, "\n\n")
+
+	fmt.Fprintf(w, "| Benchmark |")
+	for _, unit := range units {
+		fmt.Fprintf(w, " %s |", columnName(unit))
+	}
+	fmt.Fprintf(w, "\n|---|")
+	for range units {
+		fmt.Fprintf(w, "---:|")
+	}
+	fmt.Fprintf(w, "\n")
+
+	for _, b := range results.Benchmarks {
+		fmt.Fprintf(w, "| %s |", displayName(b))
+		for _, unit := range units {
+			samples, ok := b.Samples[unit]
+			if !ok {
+				fmt.Fprintf(w, " |")
+				continue
+			}
+			s := summarize(samples)
+			fmt.Fprintf(w, " %s ±%.0f%% |", formatValue(unit, s.Median), s.Spread)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// WriteComparison renders the change of every unit from old to new as
+// markdown table. Changes that are not significant at alpha are shown as ~.
+func WriteComparison(w io.Writer, oldResults, newResults *Results, alpha float64) {
+	fmt.Fprintf(w, "old: %s, new: %s\n\n", describe(oldResults.Env), describe(newResults.Env))
+	fmt.Fprintf(w, "| Benchmark | Unit | Old | New | Delta | p |\n")
+	fmt.Fprintf(w, "|---|---|---:|---:|---:|---:|\n")
+
+	index := map[string]*Benchmark{}
+	for _, b := range oldResults.Benchmarks {
+		index[b.Key()] = b
+	}
+	for _, nb := range newResults.Benchmarks {
+		ob, ok := index[nb.Key()]
+		if !ok {
+			continue
+		}
+		for _, unit := range nb.Units() {
+			oldSamples, ok := ob.Samples[unit]
+			if !ok {
+				continue
+			}
+			before, after := summarize(oldSamples), summarize(nb.Samples[unit])
+			p := mannWhitneyU(oldSamples, nb.Samples[unit])
+
+			delta := "~"
+			if p < alpha && before.Median != 0 {
+				delta = fmt.Sprintf("%+.2f%%", (after.Median-before.Median)/before.Median*100)
+			}
+			fmt.Fprintf(w, "| %s | %s | %s ±%.0f%% | %s ±%.0f%% | %s | %.3f n=%d+%d |\n",
+				displayName(nb), columnName(unit),
+				formatValue(unit, before.Median), before.Spread,
+				formatValue(unit, after.Median), after.Spread,
+				delta, p, before.N, after.N)
+		}
+	}
+}
+
+func sortUnits(units []string) {
+	b := &Benchmark{Samples: map[string][]float64{}}
+	for _, unit := range units {
+		b.Samples[unit] = nil
+	}
+	copy(units, b.Units())
+}
+
+func displayName(b *Benchmark) string {
+	name := strings.TrimPrefix(b.Name, "Benchmark")
+	if b.Procs > 1 {
+		name = fmt.Sprintf("%s-%d", name, b.Procs)
+	}
+	return name
+}
+
+func describe(env Environment) string {
+	s := env.Date.Format("2006-01-02")
+	if env.Commit != "" {
+		s += " " + shortCommit(env.Commit)
+	}
+	return s
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 8 {
+		return commit[:8]
+	}
+	return commit
+}
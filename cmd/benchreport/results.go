@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Results are the samples of all benchmarks of one or more runs of the
+// bench suite
+type Results struct {
+	Env        Environment  `json:"env"`
+	Benchmarks []*Benchmark `json:"benchmarks"`
+}
+
+// Environment describes the machine and the revision the results belong to
+type Environment struct {
+	Date      time.Time `json:"date"`
+	Commit    string    `json:"commit,omitempty"`
+	GoVersion string    `json:"go_version,omitempty"`
+	GOOS      string    `json:"goos,omitempty"`
+	GOARCH    string    `json:"goarch,omitempty"`
+	CPU       string    `json:"cpu,omitempty"`
+	NumCPU    int       `json:"num_cpu,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Pkg       string    `json:"pkg,omitempty"`
+	Args      []string  `json:"args,omitempty"`
+}
+
+// Benchmark holds one sample per run for every reported unit, eg. ns/op,
+// p99-ns or MB/s
+type Benchmark struct {
+	Name    string               `json:"name"`
+	Procs   int                  `json:"procs"`
+	Samples map[string][]float64 `json:"samples"`
+}
+
+// Key identifies the benchmark across result files
+func (b *Benchmark) Key() string {
+	return b.Name + "-" + strconv.Itoa(b.Procs)
+}
+
+// Units returns the reported units in a stable order, ns/op first
+func (b *Benchmark) Units() []string {
+	var units []string
+	for unit := range b.Samples {
+		units = append(units, unit)
+	}
+	sort.Slice(units, func(i, j int) bool {
+		ri, rj := unitRank(units[i]), unitRank(units[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return units[i] < units[j]
+	})
+	return units
+}
+
+var unitOrder = []string{"ns/op", "MB/s", "p50-ns", "p90-ns", "p99-ns", "p999-ns", "max-ns", "B/op", "allocs/op"}
+
+func unitRank(unit string) int {
+	for i, u := range unitOrder {
+		if u == unit {
+			return i
+		}
+	}
+	return len(unitOrder)
+}
+
+// Parse reads the output of go test -bench. Every line of a benchmark adds
+// one sample, thus the output of -count N has N samples per unit.
+func Parse(r io.Reader, results *Results) error {
+	index := map[string]*Benchmark{}
+	for _, b := range results.Benchmarks {
+		index[b.Key()] = b
+	}
+
+	// go test prints the name before it runs the benchmark, the logs of the
+	// benchmark may thus split the name from the results
+	var pending string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parseHeader(line, &results.Env)
+
+		b, samples, ok := parseLine(line)
+		if !ok && pending != "" {
+			b, samples, ok = parseLine(pending + " " + line)
+		}
+		if !ok {
+			if fields := strings.Fields(line); len(fields) > 0 && strings.HasPrefix(fields[0], "Benchmark") {
+				pending = fields[0]
+			}
+			continue
+		}
+		pending = ""
+		if existing, ok := index[b.Key()]; ok {
+			b = existing
+		} else {
+			index[b.Key()] = b
+			results.Benchmarks = append(results.Benchmarks, b)
+		}
+		for unit, value := range samples {
+			b.Samples[unit] = append(b.Samples[unit], value)
+		}
+	}
+	return errors.Wrap(scanner.Err(), "read benchmark output")
+}
+
+// parseHeader picks up the environment lines that go test prints before the
+// results
+func parseHeader(line string, env *Environment) {
+	for prefix, field := range map[string]*string{
+		"goos: ":   &env.GOOS,
+		"goarch: ": &env.GOARCH,
+		"cpu: ":    &env.CPU,
+		"pkg: ":    &env.Pkg,
+	} {
+		if strings.HasPrefix(line, prefix) {
+			*field = strings.TrimSpace(strings.TrimPrefix(line, prefix))
+		}
+	}
+}
+
+// parseLine parses a result line like
+// BenchmarkDirectGrpc/pingpong_proto_ingestion-8   2000   119821 ns/op   391423 p99-ns
+func parseLine(line string) (*Benchmark, map[string]float64, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || len(fields)%2 != 0 || !strings.HasPrefix(fields[0], "Benchmark") {
+		return nil, nil, false
+	}
+	if _, err := strconv.Atoi(fields[1]); err != nil {
+		return nil, nil, false
+	}
+
+	b := &Benchmark{Name: fields[0], Procs: 1, Samples: map[string][]float64{}}
+	if i := strings.LastIndex(b.Name, "-"); i > 0 {
+		if procs, err := strconv.Atoi(b.Name[i+1:]); err == nil {
+			b.Name, b.Procs = b.Name[:i], procs
+		}
+	}
+
+	samples := map[string]float64{}
+	for i := 2; i < len(fields); i += 2 {
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, nil, false
+		}
+		samples[fields[i+1]] = value
+	}
+	return b, samples, true
+}
+
+// Load reads a result file written by Save
+func Load(filename string) (*Results, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", filename)
+	}
+	results := &Results{}
+	if err := json.Unmarshal(data, results); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", filename)
+	}
+	return results, nil
+}
+
+// Save writes the results as JSON
+func (r *Results) Save(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(ioutil.WriteFile(filename, append(data, '\n'), 0644), "write %s", filename)
+}
+
+// WriteCSV writes one row per sample. The environment is repeated in every
+// row, so that the files of several releases can be concatenated.
+func (r *Results) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "commit", "go_version", "goos", "goarch", "cpu", "benchmark", "procs", "unit", "run", "value"})
+	for _, b := range r.Benchmarks {
+		for _, unit := range b.Units() {
+			for run, value := range b.Samples[unit] {
+				cw.Write([]string{
+					r.Env.Date.Format(time.RFC3339),
+					r.Env.Commit,
+					r.Env.GoVersion,
+					r.Env.GOOS,
+					r.Env.GOARCH,
+					r.Env.CPU,
+					b.Name,
+					strconv.Itoa(b.Procs),
+					unit,
+					strconv.Itoa(run),
+					strconv.FormatFloat(value, 'g', -1, 64),
+				})
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const output = `goos: linux
+goarch: amd64
+pkg: github.com/chris-rock/gyrpsy/bench
+cpu: Intel(R) Xeon(R) CPU
+BenchmarkGoGatewayGRPC/pingpong_proto_ingestion-8         	    2000	    119821 ns/op	   1266534 max-ns	    103935 p50-ns
+BenchmarkDirectGrpc/pingpong_proto_ingestion-8            	time="2018-05-01T06:21:46Z" level=info msg="Start server"
+    2000	    100000 ns/op	   1000000 max-ns	     90000 p50-ns
+BenchmarkGoGatewayGRPC/pingpong_proto_ingestion-8         	    2000	    120821 ns/op	   1366534 max-ns	    104935 p50-ns
+--- SKIP: BenchmarkNginxGrpc
+    harness_test.go:42: nginx is not installed, skipping the nginx benchmarks
+BenchmarkGoGatewayPayloadRest/pingpong_json_payload_1KB   	       1	   3050018 ns/op	   0.34 MB/s
+PASS
+ok  	github.com/chris-rock/gyrpsy/bench	2.510s
+`
+
+func TestParse(t *testing.T) {
+	results := &Results{}
+	if err := Parse(strings.NewReader(output), results); err != nil {
+		t.Fatal(err)
+	}
+
+	if results.Env.GOOS != "linux" || results.Env.CPU != "Intel(R) Xeon(R) CPU" {
+		t.Errorf("unexpected environment %+v", results.Env)
+	}
+	if len(results.Benchmarks) != 3 {
+		t.Fatalf("expected 3 benchmarks, got %d", len(results.Benchmarks))
+	}
+
+	gateway := results.Benchmarks[0]
+	if gateway.Name != "BenchmarkGoGatewayGRPC/pingpong_proto_ingestion" || gateway.Procs != 8 {
+		t.Errorf("unexpected benchmark %s-%d", gateway.Name, gateway.Procs)
+	}
+	if !reflect.DeepEqual(gateway.Samples["ns/op"], []float64{119821, 120821}) {
+		t.Errorf("unexpected samples %v", gateway.Samples["ns/op"])
+	}
+	if !reflect.DeepEqual(gateway.Units(), []string{"ns/op", "p50-ns", "max-ns"}) {
+		t.Errorf("unexpected units %v", gateway.Units())
+	}
+
+	// the log line splits the name from the results
+	if direct := results.Benchmarks[1]; direct.Samples["p50-ns"][0] != 90000 {
+		t.Errorf("unexpected samples %v", direct.Samples)
+	}
+
+	payload := results.Benchmarks[2]
+	if payload.Procs != 1 || payload.Samples["MB/s"][0] != 0.34 {
+		t.Errorf("unexpected benchmark %+v", payload)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	results := &Results{}
+	Parse(strings.NewReader(output), results)
+
+	var buf bytes.Buffer
+	if err := results.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	// header, 2x3 gateway samples, 3 direct samples, 2 payload samples
+	if len(lines) != 12 {
+		t.Fatalf("expected 12 lines, got %d\n%s", len(lines), buf.String())
+	}
+	if !strings.HasSuffix(lines[1], ",linux,amd64,Intel(R) Xeon(R) CPU,BenchmarkGoGatewayGRPC/pingpong_proto_ingestion,8,ns/op,0,119821") {
+		t.Errorf("unexpected row %s", lines[1])
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Summary describes the samples of one unit
+type Summary struct {
+	N      int
+	Mean   float64
+	Median float64
+	Min    float64
+	Max    float64
+	// Spread is the largest deviation from the median in percent
+	Spread float64
+}
+
+func summarize(samples []float64) Summary {
+	s := Summary{N: len(samples)}
+	if s.N == 0 {
+		return s
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	s.Min, s.Max = sorted[0], sorted[s.N-1]
+	if s.N%2 == 1 {
+		s.Median = sorted[s.N/2]
+	} else {
+		s.Median = (sorted[s.N/2-1] + sorted[s.N/2]) / 2
+	}
+	for _, v := range sorted {
+		s.Mean += v
+	}
+	s.Mean /= float64(s.N)
+	if s.Median != 0 {
+		s.Spread = math.Max(s.Median-s.Min, s.Max-s.Median) / s.Median * 100
+	}
+	return s
+}
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U test that
+// the samples x and y come from the same distribution. Unlike a t-test, it
+// does not assume normally distributed latencies. Small samples without ties
+// use the exact distribution of U, otherwise the normal approximation with
+// tie correction.
+func mannWhitneyU(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+
+	type sample struct {
+		value float64
+		first bool
+	}
+	all := make([]sample, 0, n1+n2)
+	for _, v := range x {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range y {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// rank with the average rank for ties
+	var rankSum, tieCorrection float64
+	ties := false
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieCorrection += t*t*t - t
+		}
+		i = j
+	}
+
+	u := rankSum - float64(n1*(n1+1))/2
+	uMin := math.Min(u, float64(n1*n2)-u)
+
+	if !ties && n1*n2 <= 400 {
+		p := 2 * exactUCDF(n1, n2, int(uMin))
+		return math.Min(p, 1)
+	}
+
+	n := float64(n1 + n2)
+	mean := float64(n1*n2) / 2
+	variance := float64(n1*n2) / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	// continuity correction
+	z := (math.Abs(u-mean) - 0.5) / math.Sqrt(variance)
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// exactUCDF returns P(U <= u) for samples of the sizes n1 and n2
+func exactUCDF(n1, n2, u int) float64 {
+	// counts[i][j][k] is the number of orderings of i and j samples with U = k,
+	// computed incrementally over the sizes
+	max := n1 * n2
+	counts := make([][][]float64, n1+1)
+	for i := range counts {
+		counts[i] = make([][]float64, n2+1)
+		for j := range counts[i] {
+			counts[i][j] = make([]float64, max+1)
+			if i == 0 || j == 0 {
+				counts[i][j][0] = 1
+				continue
+			}
+			for k := 0; k <= max; k++ {
+				// the largest value is either from the first sample, which
+				// then exceeds all j values of the second one, or not
+				if k >= j {
+					counts[i][j][k] += counts[i-1][j][k-j]
+				}
+				counts[i][j][k] += counts[i][j-1][k]
+			}
+		}
+	}
+
+	var below, total float64
+	for k, c := range counts[n1][n2] {
+		if k <= u {
+			below += c
+		}
+		total += c
+	}
+	return below / total
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	s := summarize([]float64{110, 100, 90, 105})
+	if s.N != 4 || s.Median != 102.5 || s.Min != 90 || s.Max != 110 || s.Mean != 101.25 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	tests := []struct {
+		x, y []float64
+		p    float64
+	}{
+		// identical distributions
+		{[]float64{1, 2, 3, 4, 5}, []float64{1, 2, 3, 4, 5}, 1},
+		// completely separated, exact p = 2/C(10,5)
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.0 / 252},
+		// interleaved, exact p = 2*P(U <= 10) = 174/252
+		{[]float64{1, 3, 5, 7, 9}, []float64{2, 4, 6, 8, 10}, 0.6905},
+	}
+	for _, tt := range tests {
+		if p := mannWhitneyU(tt.x, tt.y); math.Abs(p-tt.p) > 0.001 {
+			t.Errorf("%v vs %v: expected p=%.4f, got %.4f", tt.x, tt.y, tt.p, p)
+		}
+	}
+
+	// ties use the normal approximation
+	p := mannWhitneyU([]float64{1, 1, 2, 2, 3}, []float64{5, 5, 6, 6, 7})
+	if p > 0.05 || p < 0.001 {
+		t.Errorf("expected a significant difference, got p=%.4f", p)
+	}
+}
@@ -0,0 +1,20 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// adminRoutes are served by Server.Admin. On the shared port they take
+// precedence over the REST routes.
+var adminRoutes = []string{"/healthz", "/readyz", "/routes", "/metrics"}
+
+// registerPprof adds the runtime profiling routes. They are only exposed on a
+// dedicated admin port.
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
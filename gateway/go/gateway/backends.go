@@ -0,0 +1,287 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+const (
+	// RoundRobin sends the calls to the backends in turn
+	RoundRobin = "round_robin"
+	// LeastRequest sends a call to the backend with fewer calls in flight out
+	// of two random ones
+	LeastRequest = "gateway_least_request"
+)
+
+// defaultFileWatchInterval is the poll interval of file targets
+const defaultFileWatchInterval = 10 * time.Second
+
+// Backends are the GRPC servers the REST gateway balances its calls across,
+// like an upstream block of nginx
+type Backends struct {
+	// Addrs is a static list of backend addresses, eg. localhost:5001
+	Addrs []string
+	// Target is resolved by a GRPC resolver instead of Addrs, eg.
+	// dns:///pp-grpc.internal:5001, or file:///etc/gateway/backends for a file
+	// with one address per line that is watched for changes
+	Target string
+	// Balancer is RoundRobin or LeastRequest, it defaults to RoundRobin
+	Balancer string
+	// HealthCheck removes backends from the rotation while their GRPC health
+	// service does not report SERVING for HealthService. The empty service
+	// name checks the overall health of a backend.
+	HealthCheck   bool
+	HealthService string
+	// FileWatchInterval is the poll interval of file targets, it defaults to
+	// 10s
+	FileWatchInterval time.Duration
+	// Dopts are appended to the dial options, eg. the credentials of the
+	// backends
+	Dopts []grpc.DialOption
+}
+
+// ParseBackends parses a comma separated list of addresses, or a single
+// resolver target like dns:///pp-grpc.internal:5001
+func ParseBackends(s string) *Backends {
+	if strings.Contains(s, "://") {
+		return &Backends{Target: s}
+	}
+	b := &Backends{}
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			b.Addrs = append(b.Addrs, addr)
+		}
+	}
+	return b
+}
+
+// String formats the backends the way ParseBackends parses them
+func (b *Backends) String() string {
+	if b.Target != "" {
+		return b.Target
+	}
+	return strings.Join(b.Addrs, ",")
+}
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(LeastRequest, leastRequestPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+// Dial connects to the backends. The call policies are merged into the
+// service config of the balancer.
+func (b *Backends) Dial(policies []CallPolicy, dopts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	target := b.Target
+	switch {
+	case strings.HasPrefix(target, "file:"):
+		interval := b.FileWatchInterval
+		if interval == 0 {
+			interval = defaultFileWatchInterval
+		}
+		dopts = append(dopts, grpc.WithResolvers(fileResolverBuilder{interval: interval}))
+	case target == "":
+		if len(b.Addrs) == 0 {
+			return nil, errors.New("backends: addresses or target required")
+		}
+		r := manual.NewBuilderWithScheme("static")
+		var addrs []resolver.Address
+		for _, addr := range b.Addrs {
+			addrs = append(addrs, resolver.Address{Addr: addr})
+		}
+		r.InitialState(resolver.State{Addresses: addrs})
+		dopts = append(dopts, grpc.WithResolvers(r))
+		target = "static:///backends"
+	}
+
+	lb := b.Balancer
+	if lb == "" {
+		lb = RoundRobin
+	}
+	if lb != RoundRobin && lb != LeastRequest {
+		return nil, errors.Errorf("backends: unknown balancer %q", lb)
+	}
+	config := map[string]interface{}{
+		"loadBalancingConfig": []map[string]interface{}{{lb: map[string]interface{}{}}},
+	}
+	if b.HealthCheck {
+		config["healthCheckConfig"] = map[string]string{"serviceName": b.HealthService}
+	}
+	popts, err := callPolicyDialOptions(policies, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "backends")
+	}
+	dopts = append(dopts, popts...)
+	dopts = append(dopts, b.Dopts...)
+
+	conn, err := grpc.Dial(target, dopts...)
+	return conn, errors.Wrapf(err, "dial backends %s", target)
+}
+
+// leastRequestPickerBuilder picks by the calls in flight. The counts start at
+// zero when the set of ready backends changes, calls in flight are then still
+// counted on the previous picker.
+type leastRequestPickerBuilder struct{}
+
+func (leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &leastRequestPicker{}
+	for sc := range info.ReadySCs {
+		p.backends = append(p.backends, &leastRequestBackend{sc: sc})
+	}
+	return p
+}
+
+type leastRequestBackend struct {
+	sc       balancer.SubConn
+	inflight int64
+}
+
+type leastRequestPicker struct {
+	backends []*leastRequestBackend
+}
+
+// Pick compares two random backends, which avoids that all gateways pile
+// onto the same idle backend
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	b := p.backends[rand.Intn(len(p.backends))]
+	if other := p.backends[rand.Intn(len(p.backends))]; atomic.LoadInt64(&other.inflight) < atomic.LoadInt64(&b.inflight) {
+		b = other
+	}
+	atomic.AddInt64(&b.inflight, 1)
+	return balancer.PickResult{
+		SubConn: b.sc,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(&b.inflight, -1)
+		},
+	}, nil
+}
+
+// fileResolverBuilder resolves file targets to the addresses listed in the
+// file, one per line. Empty lines and lines starting with # are skipped.
+type fileResolverBuilder struct {
+	interval time.Duration
+}
+
+func (fileResolverBuilder) Scheme() string {
+	return "file"
+}
+
+func (f fileResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	// in file://backends.txt the file name is the host, the path needs to
+	// be absolute as in file:///etc/gateway/backends
+	if target.URL.Host != "" {
+		return nil, errors.Errorf("resolve %s: file targets have no host, use file:///path", target.URL.String())
+	}
+	path := target.URL.Path
+	if path == "" {
+		path = target.URL.Opaque
+	}
+	addrs, fp, err := readBackends(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cc.UpdateState(resolver.State{Addresses: addrs}); err != nil {
+		return nil, errors.Wrapf(err, "resolve %s", path)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &fileResolver{
+		path:    path,
+		cc:      cc,
+		cancel:  cancel,
+		resolve: make(chan struct{}, 1),
+	}
+	go r.watch(ctx, f.interval, fp)
+	return r, nil
+}
+
+type fileResolver struct {
+	path    string
+	cc      resolver.ClientConn
+	cancel  context.CancelFunc
+	resolve chan struct{}
+}
+
+// ResolveNow rereads the file, eg. after a backend failed
+func (r *fileResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolve <- struct{}{}:
+	default:
+	}
+}
+
+func (r *fileResolver) Close() {
+	r.cancel()
+}
+
+// watch updates the addresses when the size or modification time of the
+// file changes
+func (r *fileResolver) watch(ctx context.Context, interval time.Duration, last string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		force := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.resolve:
+			force = true
+		}
+
+		if !force && fileFingerprint(r.path) == last {
+			continue
+		}
+		addrs, fp, err := readBackends(r.path)
+		if err != nil {
+			// files may be written partially, keep the previous addresses
+			logrus.Warnf("keep previous backends: %v", err)
+			r.cc.ReportError(err)
+			continue
+		}
+		if fp != last {
+			logrus.Infof("backends of %s changed to %v", r.path, addrs)
+		}
+		last = fp
+		r.cc.UpdateState(resolver.State{Addresses: addrs})
+	}
+}
+
+// readBackends parses the backend file and returns its fingerprint
+func readBackends(path string) ([]resolver.Address, string, error) {
+	fp := fileFingerprint(path)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "read backends")
+	}
+
+	var addrs []resolver.Address
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, resolver.Address{Addr: line})
+	}
+	if len(addrs) == 0 {
+		return nil, "", errors.Errorf("read backends: no address in %s", path)
+	}
+	return addrs, fp, nil
+}
@@ -0,0 +1,264 @@
+package gateway
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// backend is a pingpong server that counts its calls and streams
+type backend struct {
+	addr    string
+	server  *grpc.Server
+	calls   int32
+	streams int32
+	health  *health.Server
+	// hold blocks the calls until release is closed
+	hold    int32
+	release chan struct{}
+}
+
+func (b *backend) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	atomic.AddInt32(&b.calls, 1)
+	if atomic.LoadInt32(&b.hold) == 1 {
+		<-b.release
+	}
+	return handler(ctx, req)
+}
+
+func (b *backend) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	atomic.AddInt32(&b.streams, 1)
+	return handler(srv, ss)
+}
+
+func startBackends(t *testing.T, n int) []*backend {
+	var backends []*backend
+	for i := 0; i < n; i++ {
+		lis, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &backend{addr: lis.Addr().String(), health: health.NewServer(), release: make(chan struct{})}
+		b.server = grpc.NewServer(grpc.UnaryInterceptor(b.interceptor), grpc.StreamInterceptor(b.streamInterceptor))
+		pingpong.RegisterPingPongServer(b.server, &pingpong.PingPongServerImpl{})
+		healthpb.RegisterHealthServer(b.server, b.health)
+		go b.server.Serve(lis)
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+func stopBackends(backends []*backend) {
+	for _, b := range backends {
+		b.server.Stop()
+	}
+}
+
+func addrs(backends []*backend) []string {
+	var addrs []string
+	for _, b := range backends {
+		addrs = append(addrs, b.addr)
+	}
+	return addrs
+}
+
+// resetCalls returns the calls per backend since the last reset
+func resetCalls(backends []*backend) []int32 {
+	var calls []int32
+	for _, b := range backends {
+		calls = append(calls, atomic.SwapInt32(&b.calls, 0))
+	}
+	return calls
+}
+
+// pingUntil calls until all backends in want received a call and the others
+// did not, it fails after a few seconds
+func pingUntil(t *testing.T, conn *grpc.ClientConn, backends []*backend, want ...int) {
+	client := pingpong.NewPingPongClient(conn)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resetCalls(backends)
+		for n := 0; n < 10*len(backends); n++ {
+			_, err := client.Ping(context.Background(), &pingpong.PingRequest{Sender: "John"}, grpc.WaitForReady(true))
+			if err != nil {
+				t.Fatalf("could not ping %v", err)
+			}
+		}
+
+		calls := resetCalls(backends)
+		ok := true
+		for i, c := range calls {
+			ok = ok && (c > 0) == contains(want, i)
+		}
+		if ok {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("expected calls to the backends %v", want)
+}
+
+func contains(ints []int, i int) bool {
+	for _, v := range ints {
+		if v == i {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBackendsRoundRobin(t *testing.T) {
+	backends := startBackends(t, 3)
+	defer stopBackends(backends)
+	s := startPingPong(t, Config{Backends: &Backends{Addrs: addrs(backends)}})
+	defer s.Shutdown(context.Background())
+
+	// wait for all backends to be ready, then they take turns
+	pingUntil(t, s.Options.Backend, backends, 0, 1, 2)
+	client := pingpong.NewPingPongClient(s.Options.Backend)
+	for n := 0; n < 30; n++ {
+		if _, err := client.Ping(context.Background(), &pingpong.PingRequest{Sender: "John"}); err != nil {
+			t.Fatalf("could not ping %v", err)
+		}
+	}
+	for i, c := range resetCalls(backends) {
+		if c != 10 {
+			t.Errorf("expected 10 calls to backend %d, got %d", i, c)
+		}
+	}
+}
+
+func TestBackendsLeastRequest(t *testing.T) {
+	backends := startBackends(t, 3)
+	defer stopBackends(backends)
+	conn, err := (&Backends{Addrs: addrs(backends), Balancer: LeastRequest}).Dial(nil, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pingUntil(t, conn, backends, 0, 1, 2)
+
+	// the first backend hangs, round robin would send it a third of the calls
+	atomic.StoreInt32(&backends[0].hold, 1)
+	client := pingpong.NewPingPongClient(conn)
+	var wg sync.WaitGroup
+	for n := 0; n < 90; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Ping(context.Background(), &pingpong.PingRequest{Sender: "John"})
+		}()
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if calls := atomic.LoadInt32(&backends[0].calls); calls >= 25 {
+		t.Errorf("expected the hanging backend to receive few calls, got %d", calls)
+	}
+	close(backends[0].release)
+	wg.Wait()
+}
+
+func TestBackendsHealthCheck(t *testing.T) {
+	for _, lb := range []string{RoundRobin, LeastRequest} {
+		t.Run(lb, func(t *testing.T) {
+			backends := startBackends(t, 2)
+			defer stopBackends(backends)
+			conn, err := (&Backends{Addrs: addrs(backends), Balancer: lb, HealthCheck: true}).Dial(nil, grpc.WithInsecure())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			pingUntil(t, conn, backends, 0, 1)
+
+			backends[0].health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			pingUntil(t, conn, backends, 1)
+
+			backends[0].health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+			pingUntil(t, conn, backends, 0, 1)
+		})
+	}
+}
+
+func TestBackendsFile(t *testing.T) {
+	backends := startBackends(t, 2)
+	defer stopBackends(backends)
+	dir, err := ioutil.TempDir("", "backends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backends")
+	if err := ioutil.WriteFile(path, []byte("# pingpong\n"+backends[0].addr+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := (&Backends{
+		Target:            "file://" + path,
+		FileWatchInterval: 10 * time.Millisecond,
+	}).Dial(nil, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pingUntil(t, conn, backends, 0)
+
+	// the size changes as well, in case the modification time does not
+	// within its resolution
+	data := fmt.Sprintf("%s\n\n%s\n", backends[0].addr, backends[1].addr)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pingUntil(t, conn, backends, 0, 1)
+
+	if err := ioutil.WriteFile(path, []byte(backends[1].addr), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pingUntil(t, conn, backends, 1)
+}
+
+func TestParseBackends(t *testing.T) {
+	b := ParseBackends("localhost:5001, localhost:5011,")
+	if len(b.Addrs) != 2 || b.Addrs[1] != "localhost:5011" || b.Target != "" {
+		t.Errorf("unexpected backends %+v", b)
+	}
+	b = ParseBackends("dns:///pp-grpc.internal:5001")
+	if b.Target != "dns:///pp-grpc.internal:5001" || len(b.Addrs) != 0 {
+		t.Errorf("unexpected backends %+v", b)
+	}
+}
+
+func TestBackendsInvalid(t *testing.T) {
+	for _, b := range []*Backends{
+		{},
+		{Addrs: []string{"localhost:5001"}, Balancer: "random"},
+		{Target: "file:///does/not/exist"},
+	} {
+		if conn, err := b.Dial(nil, grpc.WithInsecure()); err == nil {
+			conn.Close()
+			t.Errorf("expected an error for %+v", b)
+		}
+	}
+
+	// the file name must not be taken as host
+	b := &Backends{Target: "file://backends.txt"}
+	if conn, err := b.Dial(nil, grpc.WithInsecure()); err == nil || !strings.Contains(err.Error(), "no host") {
+		if conn != nil {
+			conn.Close()
+		}
+		t.Errorf("expected an error for the host of %s, got %v", b.Target, err)
+	}
+}
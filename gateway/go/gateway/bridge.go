@@ -0,0 +1,278 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/utilities"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StreamMethod describes a streaming RPC that is bridged to clients without
+// GRPC support, like browsers
+type StreamMethod struct {
+	// Method is the full GRPC method name, eg. /pingpong.PingPong/PingStream
+	Method string
+	// NewRequest and NewResponse create empty messages of the RPC types
+	NewRequest  func() proto.Message
+	NewResponse func() proto.Message
+	// ClientStreams is set for bidi streams, which are served via websockets
+	ClientStreams bool
+}
+
+// streamError is sent to the client if the stream fails
+type streamError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+var streamMarshaler = &jsonpb.Marshaler{OrigName: true}
+
+// StreamBridge returns a handler for Server.Handle that serves the streaming
+// RPCs by path. It calls the in-process GRPC server, or Config.Backends if
+// they are configured.
+//
+// Server streams accept the request as query parameters (GET) or as JSON body
+// (POST). The responses are sent as server-sent events if the client accepts
+// text/event-stream and as newline-delimited JSON otherwise, where every line
+// is either {"result": ...} or {"error": ...}.
+//
+// Bidi streams are served via websockets. Every text frame from the client is
+// a JSON request, every frame to the client a {"result": ...} or {"error": ...}
+// envelope like in newline-delimited JSON. Browsers may open them from the
+// same origin or from Config.GrpcWebOrigins.
+func StreamBridge(routes map[string]StreamMethod) func(*Options) (*http.ServeMux, error) {
+	return func(opts *Options) (*http.ServeMux, error) {
+		conn := opts.Conn
+		if opts.Backend != nil {
+			conn = opts.Backend
+		}
+
+		mux := http.NewServeMux()
+		for path, m := range routes {
+			if !strings.HasPrefix(m.Method, "/") {
+				return nil, errors.Errorf("bridge %s: method %q needs to start with /", path, m.Method)
+			}
+			if m.NewRequest == nil || m.NewResponse == nil {
+				return nil, errors.Errorf("bridge %s: message types of %s are missing", path, m.Method)
+			}
+
+			if m.ClientStreams {
+				mux.Handle(path, bidiStreamHandler(conn, m, opts.allowOrigin))
+			} else {
+				mux.Handle(path, serverStreamHandler(conn, m))
+			}
+		}
+		return mux, nil
+	}
+}
+
+// serverStreamHandler serves a server stream as SSE or newline-delimited JSON
+func serverStreamHandler(conn *grpc.ClientConn, m StreamMethod) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := m.NewRequest()
+		switch r.Method {
+		case http.MethodGet:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := runtime.PopulateQueryParameters(req, r.Form, utilities.NewDoubleArray(nil)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodPost:
+			if err := jsonpb.Unmarshal(r.Body, req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+
+		// the stream is cancelled once the client goes away
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		err := func() error {
+			stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, m.Method)
+			if err != nil {
+				return err
+			}
+			if err := stream.SendMsg(req); err != nil {
+				return err
+			}
+			if err := stream.CloseSend(); err != nil {
+				return err
+			}
+
+			for {
+				res := m.NewResponse()
+				if err := stream.RecvMsg(res); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+
+				b, err := marshalStream(res)
+				if err != nil {
+					return err
+				}
+				if sse {
+					fmt.Fprintf(w, "data: %s\n\n", b)
+				} else {
+					fmt.Fprintf(w, "{\"result\":%s}\n", b)
+				}
+				flusher.Flush()
+			}
+		}()
+		if err == nil {
+			return
+		}
+
+		logrus.Debugf("stream %s failed: %v", m.Method, err)
+		b, _ := json.Marshal(newStreamError(err))
+		if sse {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+		} else {
+			fmt.Fprintf(w, "{\"error\":%s}\n", b)
+		}
+		flusher.Flush()
+	})
+}
+
+// checkOrigin accepts websockets from the same origin and from the allowed
+// origins. Clients without origin are no browsers and are accepted as well.
+func checkOrigin(allowOrigin func(string) bool) func(*websocket.Config, *http.Request) error {
+	return func(config *websocket.Config, r *http.Request) error {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return nil
+		}
+		if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+			return nil
+		}
+		if allowOrigin != nil && allowOrigin(origin) {
+			return nil
+		}
+		return errors.Errorf("websocket origin %s is not allowed", origin)
+	}
+}
+
+// bidiStreamHandler serves a bidi stream via websockets
+func bidiStreamHandler(conn *grpc.ClientConn, m StreamMethod, allowOrigin func(string) bool) http.Handler {
+	return websocket.Server{Handshake: checkOrigin(allowOrigin), Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		ctx, cancel := context.WithCancel(ws.Request().Context())
+		defer cancel()
+
+		stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, m.Method)
+		if err != nil {
+			sendStreamError(ws, err)
+			return
+		}
+
+		// forward all frames of the client, a closed websocket ends the
+		// client side of the stream
+		reqErrc := make(chan error, 1)
+		go func() {
+			for {
+				var frame string
+				if err := websocket.Message.Receive(ws, &frame); err != nil {
+					if err != io.EOF {
+						cancel()
+						return
+					}
+					stream.CloseSend()
+					return
+				}
+
+				req := m.NewRequest()
+				if err := jsonpb.Unmarshal(strings.NewReader(frame), req); err != nil {
+					reqErrc <- status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+					cancel()
+					return
+				}
+				if err := stream.SendMsg(req); err != nil {
+					// the error is reported by RecvMsg
+					return
+				}
+			}
+		}()
+
+		for {
+			res := m.NewResponse()
+			if err := stream.RecvMsg(res); err == io.EOF {
+				return
+			} else if err != nil {
+				// report the invalid request instead of the cancellation
+				select {
+				case err = <-reqErrc:
+				default:
+				}
+				sendStreamError(ws, err)
+				return
+			}
+
+			b, err := marshalStream(res)
+			if err != nil {
+				sendStreamError(ws, err)
+				return
+			}
+			if err := websocket.Message.Send(ws, fmt.Sprintf("{\"result\":%s}", b)); err != nil {
+				return
+			}
+		}
+	}}
+}
+
+func marshalStream(m proto.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := streamMarshaler.Marshal(&buf, m); err != nil {
+		return nil, errors.Wrap(err, "marshal stream message")
+	}
+	return buf.Bytes(), nil
+}
+
+func newStreamError(err error) *streamError {
+	st := status.Convert(err)
+	return &streamError{
+		Code:    int(st.Code()),
+		Message: st.Message(),
+	}
+}
+
+func sendStreamError(ws *websocket.Conn, err error) {
+	b, _ := json.Marshal(newStreamError(err))
+	websocket.Message.Send(ws, fmt.Sprintf("{\"error\":%s}", b))
+}
@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"golang.org/x/net/websocket"
+)
+
+// startBridge mounts the pingpong streams at /stream/ and serves the REST
+// routes via an http test server
+func startBridge(t *testing.T, config Config) (*Server, *httptest.Server) {
+	s := startPingPong(t, config)
+	err := s.Handle("/stream/", StreamBridge(map[string]StreamMethod{
+		"/ping": {
+			Method:      "/pingpong.PingPong/PingStream",
+			NewRequest:  func() proto.Message { return &pingpong.PingStreamRequest{} },
+			NewResponse: func() proto.Message { return &pingpong.PongReply{} },
+		},
+		"/pingpong": {
+			Method:        "/pingpong.PingPong/PingPongStream",
+			NewRequest:    func() proto.Message { return &pingpong.PingRequest{} },
+			NewResponse:   func() proto.Message { return &pingpong.PongReply{} },
+			ClientStreams: true,
+		},
+	}))
+	if err != nil {
+		t.Fatalf("could not register bridge %v", err)
+	}
+	return s, httptest.NewServer(s.Mux)
+}
+
+func TestStreamBridgeNDJSON(t *testing.T) {
+	s, ts := startBridge(t, Config{})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/stream/ping", "application/json", strings.NewReader(`{"sender": "John", "count": 3}`))
+	if err != nil {
+		t.Fatalf("could not call stream %v", err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected application/x-ndjson, got %s", ct)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	expected := []string{
+		`{"result":{"message":"Hello John 0"}}`,
+		`{"result":{"message":"Hello John 1"}}`,
+		`{"result":{"message":"Hello John 2"}}`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestStreamBridgeSSE(t *testing.T) {
+	s, ts := startBridge(t, Config{})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/stream/ping?sender=John&count=2", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not call stream %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("could not read stream %v", err)
+	}
+	expected := "data: {\"message\":\"Hello John 0\"}\n\ndata: {\"message\":\"Hello John 1\"}\n\n"
+	if string(body) != expected {
+		t.Fatalf("expected %q, got %q", expected, body)
+	}
+}
+
+func TestStreamBridgeWebsocket(t *testing.T) {
+	s, ts := startBridge(t, Config{})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	ws, err := websocket.Dial(strings.Replace(ts.URL, "http", "ws", 1)+"/stream/pingpong", "", ts.URL)
+	if err != nil {
+		t.Fatalf("could not open websocket %v", err)
+	}
+	defer ws.Close()
+
+	for _, sender := range []string{"John", "Jane"} {
+		if err := websocket.Message.Send(ws, `{"sender": "`+sender+`"}`); err != nil {
+			t.Fatalf("could not send %v", err)
+		}
+		var frame string
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			t.Fatalf("could not receive %v", err)
+		}
+		if expected := `{"result":{"message":"Hello ` + sender + `"}}`; frame != expected {
+			t.Errorf("expected %s, got %s", expected, frame)
+		}
+	}
+
+	if err := websocket.Message.Send(ws, `not json`); err != nil {
+		t.Fatalf("could not send %v", err)
+	}
+	var frame string
+	if err := websocket.Message.Receive(ws, &frame); err != nil {
+		t.Fatalf("could not receive %v", err)
+	}
+	if !strings.HasPrefix(frame, `{"error":{"code":3,`) {
+		t.Errorf("expected invalid argument error, got %s", frame)
+	}
+}
+
+func TestStreamBridgeWebsocketOrigin(t *testing.T) {
+	s, ts := startBridge(t, Config{GrpcWebOrigins: []string{"https://app.gyrpsy.local"}})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	url := strings.Replace(ts.URL, "http", "ws", 1) + "/stream/pingpong"
+	for origin, allowed := range map[string]bool{
+		ts.URL:                      true,
+		"https://app.gyrpsy.local":  true,
+		"https://evil.gyrpsy.local": false,
+	} {
+		ws, err := websocket.Dial(url, "", origin)
+		if err == nil {
+			ws.Close()
+		}
+		if allowed && err != nil {
+			t.Errorf("expected origin %s to be allowed, got %v", origin, err)
+		} else if !allowed && err == nil {
+			t.Errorf("expected origin %s to be rejected", origin)
+		}
+	}
+}
+
+func TestStreamBridgeBackends(t *testing.T) {
+	backends := startBackends(t, 1)
+	defer stopBackends(backends)
+	s, ts := startBridge(t, Config{Backends: &Backends{Addrs: addrs(backends)}})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/stream/ping", "application/json", strings.NewReader(`{"sender": "John", "count": 1}`))
+	if err != nil {
+		t.Fatalf("could not call stream %v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("could not read stream %v", err)
+	}
+	if expected := "{\"result\":{\"message\":\"Hello John 0\"}}\n"; string(body) != expected {
+		t.Fatalf("expected %q, got %q", expected, body)
+	}
+	if calls := atomic.LoadInt32(&backends[0].streams); calls != 1 {
+		t.Errorf("expected the stream to be served by the backend, got %d streams", calls)
+	}
+}
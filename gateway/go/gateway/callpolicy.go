@@ -0,0 +1,373 @@
+package gateway
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// CallPolicy configures the calls of the REST gateway to GRPC methods. It is
+// rendered into the GRPC service config of the gateway connections.
+type CallPolicy struct {
+	// Service is the full name of the GRPC service, eg. pingpong.PingPong
+	Service string
+	// Method limits the policy to one method, all methods of the service are
+	// selected if it is empty. Method policies win over service policies.
+	Method string
+	// Timeout is the deadline of a call. A shorter deadline of the HTTP
+	// request, eg. via the Grpc-Timeout header, takes precedence.
+	Timeout time.Duration
+	// Retry and Hedging are mutually exclusive
+	Retry   *RetryPolicy
+	Hedging *HedgingPolicy
+}
+
+// RetryPolicy retries failed calls with exponential backoff
+type RetryPolicy struct {
+	// MaxAttempts includes the original call, it defaults to 3 and is
+	// limited to 5
+	MaxAttempts int
+	// InitialBackoff defaults to 100ms, MaxBackoff to 1s and
+	// BackoffMultiplier to 2
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// RetryableCodes defaults to Unavailable
+	RetryableCodes []codes.Code
+}
+
+// HedgingPolicy sends additional attempts of a call if no response arrived
+// within HedgingDelay. The first successful response wins. Use it for
+// idempotent methods only.
+type HedgingPolicy struct {
+	// MaxAttempts includes the original call, it defaults to 2 and is
+	// limited to 5
+	MaxAttempts int
+	// HedgingDelay is the delay between the attempts, all attempts are sent
+	// at once if it is 0
+	HedgingDelay time.Duration
+	// NonFatalCodes start the next attempt immediately instead of failing the
+	// call, it defaults to Unavailable
+	NonFatalCodes []codes.Code
+}
+
+// codeNames are the status code names of the service config
+var codeNames = map[codes.Code]string{
+	codes.OK:                 "OK",
+	codes.Canceled:           "CANCELLED",
+	codes.Unknown:            "UNKNOWN",
+	codes.InvalidArgument:    "INVALID_ARGUMENT",
+	codes.DeadlineExceeded:   "DEADLINE_EXCEEDED",
+	codes.NotFound:           "NOT_FOUND",
+	codes.AlreadyExists:      "ALREADY_EXISTS",
+	codes.PermissionDenied:   "PERMISSION_DENIED",
+	codes.ResourceExhausted:  "RESOURCE_EXHAUSTED",
+	codes.FailedPrecondition: "FAILED_PRECONDITION",
+	codes.Aborted:            "ABORTED",
+	codes.OutOfRange:         "OUT_OF_RANGE",
+	codes.Unimplemented:      "UNIMPLEMENTED",
+	codes.Internal:           "INTERNAL",
+	codes.Unavailable:        "UNAVAILABLE",
+	codes.DataLoss:           "DATA_LOSS",
+	codes.Unauthenticated:    "UNAUTHENTICATED",
+}
+
+// maxAttempts is the limit of GRPC for the attempts of retries and hedging,
+// larger values are clamped to it like GRPC does
+const maxAttempts = 5
+
+func (p CallPolicy) withDefaults() (CallPolicy, error) {
+	if p.Service == "" {
+		return p, errors.New("call policy: service is required")
+	}
+	if p.Retry != nil && p.Hedging != nil {
+		return p, errors.Errorf("call policy %s: retry and hedging are mutually exclusive", p.name())
+	}
+	if p.Retry != nil {
+		r := *p.Retry
+		if r.MaxAttempts == 0 {
+			r.MaxAttempts = 3
+		}
+		if r.InitialBackoff == 0 {
+			r.InitialBackoff = 100 * time.Millisecond
+		}
+		if r.MaxBackoff == 0 {
+			r.MaxBackoff = time.Second
+		}
+		if r.BackoffMultiplier == 0 {
+			r.BackoffMultiplier = 2
+		}
+		if len(r.RetryableCodes) == 0 {
+			r.RetryableCodes = []codes.Code{codes.Unavailable}
+		}
+		if r.MaxAttempts > maxAttempts {
+			r.MaxAttempts = maxAttempts
+		}
+		if r.MaxAttempts < 2 || r.InitialBackoff < 0 || r.MaxBackoff < r.InitialBackoff || r.BackoffMultiplier < 0 {
+			return p, errors.Errorf("call policy %s: invalid retry policy %+v", p.name(), r)
+		}
+		p.Retry = &r
+	}
+	if p.Hedging != nil {
+		h := *p.Hedging
+		if h.MaxAttempts == 0 {
+			h.MaxAttempts = 2
+		}
+		if len(h.NonFatalCodes) == 0 {
+			h.NonFatalCodes = []codes.Code{codes.Unavailable}
+		}
+		if h.MaxAttempts > maxAttempts {
+			h.MaxAttempts = maxAttempts
+		}
+		if h.MaxAttempts < 2 || h.HedgingDelay < 0 {
+			return p, errors.Errorf("call policy %s: invalid hedging policy %+v", p.name(), h)
+		}
+		p.Hedging = &h
+	}
+	return p, nil
+}
+
+// name is the GRPC method name the policy applies to, eg.
+// /pingpong.PingPong/Ping, or the service prefix /pingpong.PingPong/
+func (p CallPolicy) name() string {
+	return "/" + p.Service + "/" + p.Method
+}
+
+func durationJSON(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+func codesJSON(cs []codes.Code) []string {
+	names := make([]string, len(cs))
+	for i, c := range cs {
+		names[i] = codeNames[c]
+	}
+	return names
+}
+
+type methodConfig struct {
+	Name          []map[string]string    `json:"name"`
+	Timeout       string                 `json:"timeout,omitempty"`
+	RetryPolicy   map[string]interface{} `json:"retryPolicy,omitempty"`
+	HedgingPolicy map[string]interface{} `json:"hedgingPolicy,omitempty"`
+}
+
+// ServiceConfig renders the policies as GRPC service config
+func ServiceConfig(policies []CallPolicy) (string, error) {
+	return serviceConfig(policies, map[string]interface{}{})
+}
+
+// serviceConfig adds the policies to the other settings of the service
+// config, eg. the load balancing
+func serviceConfig(policies []CallPolicy, config map[string]interface{}) (string, error) {
+	var configs []methodConfig
+	for _, p := range policies {
+		p, err := p.withDefaults()
+		if err != nil {
+			return "", err
+		}
+
+		name := map[string]string{"service": p.Service}
+		if p.Method != "" {
+			name["method"] = p.Method
+		}
+		mc := methodConfig{Name: []map[string]string{name}}
+		if p.Timeout > 0 {
+			mc.Timeout = durationJSON(p.Timeout)
+		}
+		if r := p.Retry; r != nil {
+			mc.RetryPolicy = map[string]interface{}{
+				"maxAttempts":          r.MaxAttempts,
+				"initialBackoff":       durationJSON(r.InitialBackoff),
+				"maxBackoff":           durationJSON(r.MaxBackoff),
+				"backoffMultiplier":    r.BackoffMultiplier,
+				"retryableStatusCodes": codesJSON(r.RetryableCodes),
+			}
+		}
+		if h := p.Hedging; h != nil {
+			mc.HedgingPolicy = map[string]interface{}{
+				"maxAttempts":         h.MaxAttempts,
+				"hedgingDelay":        durationJSON(h.HedgingDelay),
+				"nonFatalStatusCodes": codesJSON(h.NonFatalCodes),
+			}
+		}
+		configs = append(configs, mc)
+	}
+	if len(configs) > 0 {
+		config["methodConfig"] = configs
+	}
+
+	b, err := json.Marshal(config)
+	return string(b), err
+}
+
+// CallPolicyDialOptions configures a client connection with the policies. The
+// GRPC client implements timeouts and retries of the service config, but
+// ignores the hedging policy, therefore hedging is done by an interceptor.
+func CallPolicyDialOptions(policies []CallPolicy) ([]grpc.DialOption, error) {
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	return callPolicyDialOptions(policies, map[string]interface{}{})
+}
+
+func callPolicyDialOptions(policies []CallPolicy, config map[string]interface{}) ([]grpc.DialOption, error) {
+	sc, err := serviceConfig(policies, config)
+	if err != nil {
+		return nil, err
+	}
+	dopts := []grpc.DialOption{grpc.WithDefaultServiceConfig(sc)}
+
+	// methods without hedging are recorded as well, they must not fall back
+	// to the hedging policy of their service
+	hedging := map[string]*HedgingPolicy{}
+	hedged := false
+	for _, p := range policies {
+		p, _ = p.withDefaults()
+		hedging[p.name()] = p.Hedging
+		hedged = hedged || p.Hedging != nil
+	}
+	if hedged {
+		dopts = append(dopts, grpc.WithChainUnaryInterceptor(hedgingInterceptor(hedging)))
+	}
+	return dopts, nil
+}
+
+// hedgingPolicy looks up the policy of the method, eg. /pingpong.PingPong/Ping,
+// falling back to the policy of its service
+func hedgingPolicy(policies map[string]*HedgingPolicy, method string) *HedgingPolicy {
+	if p, ok := policies[method]; ok {
+		return p
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		return policies[method[:i+1]]
+	}
+	return nil
+}
+
+func isCode(err error, cs []codes.Code) bool {
+	code := status.Code(err)
+	for _, c := range cs {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// hedgingInterceptor sends up to MaxAttempts concurrent attempts of unary
+// calls, HedgingDelay apart. It returns the first successful response and
+// cancels the other attempts.
+func hedgingInterceptor(policies map[string]*HedgingPolicy) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		policy := hedgingPolicy(policies, method)
+		replyMsg, ok := reply.(proto.Message)
+		if policy == nil || !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type attempt struct {
+			reply           proto.Message
+			header, trailer metadata.MD
+			err             error
+		}
+		results := make(chan attempt, policy.MaxAttempts)
+		start := func() {
+			a := attempt{reply: proto.Clone(replyMsg)}
+			a.reply.Reset()
+			// every attempt receives its own metadata, the caller gets the
+			// metadata of the winner
+			aopts := append(filterMetadataOptions(opts), grpc.Header(&a.header), grpc.Trailer(&a.trailer))
+			a.err = invoker(ctx, method, req, a.reply, cc, aopts...)
+			results <- a
+		}
+
+		go start()
+		started, finished := 1, 0
+		timer := time.NewTimer(policy.HedgingDelay)
+		defer timer.Stop()
+
+		var lastErr error
+		for {
+			select {
+			case <-timer.C:
+				if started < policy.MaxAttempts {
+					go start()
+					started++
+					timer.Reset(policy.HedgingDelay)
+				}
+			case a := <-results:
+				finished++
+				if a.err == nil {
+					proto.Merge(replyMsg, a.reply)
+					setMetadataOptions(opts, a.header, a.trailer)
+					return nil
+				}
+				lastErr = a.err
+				if !isCode(a.err, policy.NonFatalCodes) {
+					setMetadataOptions(opts, a.header, a.trailer)
+					return a.err
+				}
+				// a non-fatal error triggers the next attempt right away
+				if started < policy.MaxAttempts {
+					go start()
+					started++
+					resetTimer(timer, policy.HedgingDelay)
+				} else if finished == started {
+					setMetadataOptions(opts, a.header, a.trailer)
+					return lastErr
+				}
+			}
+		}
+	}
+}
+
+// resetTimer restarts the timer, a tick that was not received yet is dropped
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// filterMetadataOptions removes the header and trailer options, since the
+// attempts must not write the metadata of the caller concurrently
+func filterMetadataOptions(opts []grpc.CallOption) []grpc.CallOption {
+	var filtered []grpc.CallOption
+	for _, o := range opts {
+		switch o.(type) {
+		case grpc.HeaderCallOption, grpc.TrailerCallOption:
+		default:
+			filtered = append(filtered, o)
+		}
+	}
+	return filtered
+}
+
+// setMetadataOptions passes the metadata to the header and trailer options of
+// the caller
+func setMetadataOptions(opts []grpc.CallOption, header, trailer metadata.MD) {
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = header
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = trailer
+		}
+	}
+}
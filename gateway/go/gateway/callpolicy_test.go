@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// faultyBackend fails or delays the calls of the GRPC server
+type faultyBackend struct {
+	calls int32
+	// fail returns the error of the call with the given number, starting at 1
+	fail func(call int32) error
+	// delay returns the delay of the call with the given number
+	delay func(call int32) time.Duration
+	// deadline is the remaining time of the last call
+	deadline atomic.Value
+}
+
+func (f *faultyBackend) interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	call := atomic.AddInt32(&f.calls, 1)
+	if deadline, ok := ctx.Deadline(); ok {
+		f.deadline.Store(time.Until(deadline))
+	}
+	if f.delay != nil {
+		select {
+		case <-time.After(f.delay(call)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if f.fail != nil {
+		if err := f.fail(call); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// startFaultyRest runs the REST gateway via Options.Conn in front of the
+// faulty backend
+func startFaultyRest(t *testing.T, f *faultyBackend, policies ...CallPolicy) (*Server, *httptest.Server) {
+	s := startPingPong(t, Config{CallPolicies: policies}, WithUnaryInterceptors(f.interceptor))
+	gwmux := runtime.NewServeMux()
+	if err := pingpong.RegisterPingPongHandler(context.Background(), gwmux, s.Options.Conn); err != nil {
+		t.Fatalf("could not register gateway %v", err)
+	}
+	return s, httptest.NewServer(gwmux)
+}
+
+func ping(t *testing.T, url string, header http.Header) *http.Response {
+	req, _ := http.NewRequest(http.MethodPost, url+"/ping", bytes.NewBufferString(`{"sender": "John"}`))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not call %v", err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestServiceConfig(t *testing.T) {
+	sc, err := ServiceConfig([]CallPolicy{
+		{Service: "pingpong.PingPong", Timeout: 1500 * time.Millisecond, Retry: &RetryPolicy{}},
+		{Service: "pingpong.PingPong", Method: "Ping", Hedging: &HedgingPolicy{HedgingDelay: 10 * time.Millisecond}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"name":[{"service":"pingpong.PingPong"}]`,
+		`"timeout":"1.5s"`,
+		`"retryPolicy":{"backoffMultiplier":2,"initialBackoff":"0.1s","maxAttempts":3,"maxBackoff":"1s","retryableStatusCodes":["UNAVAILABLE"]}`,
+		`"name":[{"method":"Ping","service":"pingpong.PingPong"}]`,
+		`"hedgingPolicy":{"hedgingDelay":"0.01s","maxAttempts":2,"nonFatalStatusCodes":["UNAVAILABLE"]}`,
+	} {
+		if !strings.Contains(sc, expected) {
+			t.Errorf("expected %s in %s", expected, sc)
+		}
+	}
+
+	// the attempts are limited like GRPC limits them
+	sc, err = ServiceConfig([]CallPolicy{
+		{Service: "pingpong.PingPong", Retry: &RetryPolicy{MaxAttempts: 10}},
+		{Service: "pingpong.PingPong", Method: "Ping", Hedging: &HedgingPolicy{MaxAttempts: 10}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(sc, `"maxAttempts":5`) != 2 {
+		t.Errorf("expected 5 attempts in %s", sc)
+	}
+
+	_, err = ServiceConfig([]CallPolicy{{Service: "pingpong.PingPong", Retry: &RetryPolicy{}, Hedging: &HedgingPolicy{}}})
+	if err == nil {
+		t.Errorf("expected retry and hedging to be exclusive")
+	}
+}
+
+func TestCallPolicyRetry(t *testing.T) {
+	f := &faultyBackend{fail: func(call int32) error {
+		if call <= 2 {
+			return status.Error(codes.Unavailable, "backend is restarting")
+		}
+		return nil
+	}}
+	s, ts := startFaultyRest(t, f, CallPolicy{
+		Service: "pingpong.PingPong",
+		Retry:   &RetryPolicy{InitialBackoff: 10 * time.Millisecond},
+	})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	if res := ping(t, ts.URL, nil); res.StatusCode != http.StatusOK {
+		t.Errorf("expected the retry to succeed, got %d", res.StatusCode)
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+
+	// other codes are not retried
+	f.calls = 0
+	f.fail = func(int32) error { return status.Error(codes.Internal, "broken") }
+	if res := ping(t, ts.URL, nil); res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", res.StatusCode)
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 1 {
+		t.Errorf("expected 1 attempt, got %d", calls)
+	}
+}
+
+func TestCallPolicyDeadline(t *testing.T) {
+	f := &faultyBackend{delay: func(int32) time.Duration { return 500 * time.Millisecond }}
+	s, ts := startFaultyRest(t, f, CallPolicy{
+		Service: "pingpong.PingPong",
+		Timeout: 200 * time.Millisecond,
+	})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	// the timeout of the policy applies without Grpc-Timeout
+	if res := ping(t, ts.URL, nil); res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d", res.StatusCode)
+	}
+	if d := f.deadline.Load().(time.Duration); d > 200*time.Millisecond || d < 100*time.Millisecond {
+		t.Errorf("expected the backend deadline of the policy, got %v", d)
+	}
+
+	// a shorter Grpc-Timeout of the request propagates to the backend
+	start := time.Now()
+	if res := ping(t, ts.URL, http.Header{"Grpc-Timeout": {"50m"}}); res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("expected 504, got %d", res.StatusCode)
+	}
+	if d := f.deadline.Load().(time.Duration); d > 50*time.Millisecond {
+		t.Errorf("expected the backend deadline of the request, got %v", d)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected the call to fail after 50ms, got %v", elapsed)
+	}
+}
+
+func TestCallPolicyHedging(t *testing.T) {
+	// the first attempt hangs, the hedged one answers right away
+	f := &faultyBackend{delay: func(call int32) time.Duration {
+		if call == 1 {
+			return 5 * time.Second
+		}
+		return 0
+	}}
+	s, ts := startFaultyRest(t, f, CallPolicy{
+		Service: "pingpong.PingPong",
+		Method:  "Ping",
+		Hedging: &HedgingPolicy{HedgingDelay: 20 * time.Millisecond},
+	})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	start := time.Now()
+	if res := ping(t, ts.URL, nil); res.StatusCode != http.StatusOK {
+		t.Errorf("expected the hedged call to succeed, got %d", res.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the hedged response, got it after %v", elapsed)
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls)
+	}
+}
+
+func TestCallPolicyHedgingMaxAttempts(t *testing.T) {
+	f := &faultyBackend{fail: func(int32) error {
+		return status.Error(codes.Unavailable, "backend is restarting")
+	}}
+	s, ts := startFaultyRest(t, f, CallPolicy{
+		Service: "pingpong.PingPong",
+		Method:  "Ping",
+		Hedging: &HedgingPolicy{MaxAttempts: 10, HedgingDelay: time.Second},
+	})
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	// non-fatal errors start the next attempt right away, up to the limit
+	start := time.Now()
+	if res := ping(t, ts.URL, nil); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", res.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the attempts without delay, took %v", elapsed)
+	}
+	if calls := atomic.LoadInt32(&f.calls); calls != maxAttempts {
+		t.Errorf("expected %d attempts, got %d", maxAttempts, calls)
+	}
+}
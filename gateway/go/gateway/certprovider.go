@@ -0,0 +1,231 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+)
+
+// CertProvider holds the key pair and the CA pool of the server. Reload swaps
+// both atomically, so that certificates can be rotated without a restart.
+type CertProvider struct {
+	files []string
+	load  func() (cert []byte, key []byte, ca []byte, err error)
+
+	mu    sync.Mutex // serializes reloads
+	state atomic.Value
+}
+
+type certState struct {
+	keyPair  *tls.Certificate
+	certPool *x509.CertPool
+}
+
+// NewCertProvider creates a provider for PEM encoded certificates. The ca
+// bundle is optional, see LoadCert.
+func NewCertProvider(cert []byte, key []byte, ca []byte) (*CertProvider, error) {
+	p := &CertProvider{
+		load: func() ([]byte, []byte, []byte, error) {
+			return cert, key, ca, nil
+		},
+	}
+	return p, p.Reload()
+}
+
+// NewFileCertProvider creates a provider that reads the PEM files from disk.
+// The caFile is optional. Use Watch or Reload to pick up rotated files.
+func NewFileCertProvider(certFile string, keyFile string, caFile string) (*CertProvider, error) {
+	p := &CertProvider{
+		files: []string{certFile, keyFile},
+	}
+	if caFile != "" {
+		p.files = append(p.files, caFile)
+	}
+	p.load = func() (cert []byte, key []byte, ca []byte, err error) {
+		if cert, err = ioutil.ReadFile(certFile); err != nil {
+			return nil, nil, nil, err
+		}
+		if key, err = ioutil.ReadFile(keyFile); err != nil {
+			return nil, nil, nil, err
+		}
+		if caFile != "" {
+			if ca, err = ioutil.ReadFile(caFile); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		return cert, key, ca, nil
+	}
+	return p, p.Reload()
+}
+
+// Reload loads the certificates again and swaps them atomically. On error,
+// the previous certificates stay in place.
+func (p *CertProvider) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cert, key, ca, err := p.load()
+	if err != nil {
+		return errors.Wrap(err, "reload certificates")
+	}
+	var bundles [][]byte
+	if len(ca) > 0 {
+		bundles = append(bundles, ca)
+	}
+	keyPair, certPool, err := LoadCert(cert, key, bundles...)
+	if err != nil {
+		return errors.Wrap(err, "reload certificates")
+	}
+	p.state.Store(&certState{keyPair: keyPair, certPool: certPool})
+	return nil
+}
+
+// Watch polls the certificate files and reloads them once they change. It
+// returns when the context is done.
+func (p *CertProvider) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := p.fingerprint()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fp := p.fingerprint()
+		if fp == last {
+			continue
+		}
+		if err := p.Reload(); err != nil {
+			// files may be written partially, retry on next tick
+			logrus.Warnf("keep previous certificates: %v", err)
+			continue
+		}
+		logrus.Info("reloaded certificates")
+		last = fp
+	}
+}
+
+// fingerprint summarizes size and modification time of all watched files
+func (p *CertProvider) fingerprint() string {
+	return fileFingerprint(p.files...)
+}
+
+// fileFingerprint summarizes size and modification time of the files, it
+// changes when one of them is written, created or removed
+func fileFingerprint(files ...string) string {
+	fp := ""
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			fp += f + ":missing;"
+			continue
+		}
+		fp += fmt.Sprintf("%s:%d:%d;", f, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return fp
+}
+
+func (p *CertProvider) current() *certState {
+	return p.state.Load().(*certState)
+}
+
+// KeyPair returns the current key pair
+func (p *CertProvider) KeyPair() *tls.Certificate {
+	return p.current().keyPair
+}
+
+// CertPool returns the current pool of trusted root certificates
+func (p *CertProvider) CertPool() *x509.CertPool {
+	return p.current().certPool
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (p *CertProvider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.KeyPair(), nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate
+func (p *CertProvider) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return p.KeyPair(), nil
+}
+
+// serverTLSConfig returns a TLS config that always uses the current key pair
+// and, for mutual TLS, the current CA pool
+func (p *CertProvider) serverTLSConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		GetCertificate: p.GetCertificate,
+		NextProtos:     []string{"h2"},
+		ClientAuth:     clientAuth,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				GetCertificate: p.GetCertificate,
+				NextProtos:     []string{"h2"},
+				ClientAuth:     clientAuth,
+				ClientCAs:      p.CertPool(),
+			}, nil
+		},
+	}
+}
+
+// clientCreds returns GRPC transport credentials that verify the server
+// against the current CA pool on every handshake
+func (p *CertProvider) clientCreds(serverName string, clientCert bool) credentials.TransportCredentials {
+	return &providerCreds{
+		provider:   p,
+		serverName: serverName,
+		clientCert: clientCert,
+	}
+}
+
+type providerCreds struct {
+	provider   *CertProvider
+	serverName string
+	clientCert bool
+}
+
+func (c *providerCreds) creds() credentials.TransportCredentials {
+	cfg := &tls.Config{
+		ServerName: c.serverName,
+		RootCAs:    c.provider.CertPool(),
+	}
+	if c.clientCert {
+		cfg.GetClientCertificate = c.provider.GetClientCertificate
+	}
+	return credentials.NewTLS(cfg)
+}
+
+func (c *providerCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.creds().ClientHandshake(ctx, authority, conn)
+}
+
+func (c *providerCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.creds().ServerHandshake(conn)
+}
+
+func (c *providerCreds) Info() credentials.ProtocolInfo {
+	return c.creds().Info()
+}
+
+func (c *providerCreds) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+func (c *providerCreds) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
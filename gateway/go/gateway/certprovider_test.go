@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// certFiles writes a server certificate of the CA and the CA itself
+func certFiles(t *testing.T, dir string, ca *testCA) *x509.Certificate {
+	cert, key := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost"},
+	})
+	for name, data := range map[string][]byte{"cert.pem": cert, "key.pem": key, "ca.pem": ca.pem} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
+
+// serveTLS completes the handshakes of all connections with the TLS config
+// of the provider
+func serveTLS(t *testing.T, p *CertProvider) net.Listener {
+	lis, err := tls.Listen("tcp", "localhost:0", p.serverTLSConfig(tls.RequireAndVerifyClientCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	return lis
+}
+
+// handshake returns the leaf of the server or the error of the handshake. A
+// rejected client certificate only shows up on the first read with TLS 1.3.
+func handshake(t *testing.T, addr string, ca, client *testCA) (*x509.Certificate, error) {
+	cert, key := client.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client"}})
+	conn, err := tls.Dial("tcp", addr, clientTLS(t, ca, cert, key))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestCertProviderRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldCA, newCA := newTestCA(t, "old CA"), newTestCA(t, "new CA")
+	oldLeaf := certFiles(t, dir, oldCA)
+	p, err := NewFileCertProvider(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := serveTLS(t, p)
+	defer lis.Close()
+	addr := lis.Addr().String()
+
+	leaf, err := handshake(t, addr, oldCA, oldCA)
+	if err != nil {
+		t.Fatalf("could not handshake %v", err)
+	}
+	if !leaf.Equal(oldLeaf) {
+		t.Errorf("expected the initial certificate")
+	}
+
+	// Reload
+	newLeaf := certFiles(t, dir, newCA)
+	if err := p.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err = handshake(t, addr, newCA, newCA)
+	if err != nil {
+		t.Fatalf("could not handshake after reload %v", err)
+	}
+	if !leaf.Equal(newLeaf) {
+		t.Errorf("expected the rotated certificate")
+	}
+	if _, err := handshake(t, addr, newCA, oldCA); err == nil {
+		t.Errorf("expected clients of the old CA to be rejected")
+	}
+
+	// Watch
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Watch(ctx, 10*time.Millisecond)
+	// the modification time may not change within its resolution, the
+	// watcher needs to see the new files after it started
+	time.Sleep(50 * time.Millisecond)
+	watchedLeaf := certFiles(t, dir, oldCA)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if leaf, err := x509.ParseCertificate(p.KeyPair().Certificate[0]); err == nil && leaf.Equal(watchedLeaf) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	leaf, err = handshake(t, addr, oldCA, oldCA)
+	if err != nil {
+		t.Fatalf("could not handshake after watch %v", err)
+	}
+	if !leaf.Equal(watchedLeaf) {
+		t.Errorf("expected the watched certificate")
+	}
+	if _, err := handshake(t, addr, oldCA, newCA); err == nil {
+		t.Errorf("expected clients of the new CA to be rejected")
+	}
+}
@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+)
+
+// grpcWebFrame encodes a message as length-prefixed data frame
+func grpcWebFrame(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatalf("could not marshal %v", err)
+	}
+	frame := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	return append(frame, b...)
+}
+
+// readGrpcWebReply decodes the data frame and the trailer frame of a response
+func readGrpcWebReply(t *testing.T, body []byte) (*pingpong.PongReply, string) {
+	reply := &pingpong.PongReply{}
+	var trailer string
+	for len(body) >= 5 {
+		flags := body[0]
+		n := binary.BigEndian.Uint32(body[1:5])
+		payload := body[5 : 5+n]
+		body = body[5+n:]
+		if flags&0x80 != 0 {
+			trailer = string(payload)
+			continue
+		}
+		if err := proto.Unmarshal(payload, reply); err != nil {
+			t.Fatalf("could not unmarshal reply %v", err)
+		}
+	}
+	return reply, trailer
+}
+
+func startGrpcWeb(t *testing.T) (*Server, *httptest.Server) {
+	s := startPingPong(t, Config{
+		GrpcWeb:        true,
+		GrpcWebOrigins: []string{"https://example.com"},
+	})
+	return s, httptest.NewServer(s.grpcHandlerFunc(s.GRPC, s.Mux))
+}
+
+func TestGrpcWeb(t *testing.T) {
+	s, ts := startGrpcWeb(t)
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	tests := []struct {
+		contentType string
+		encode      func([]byte) []byte
+		decode      func([]byte) []byte
+	}{
+		{"application/grpc-web+proto", nil, nil},
+		{"application/grpc-web-text", func(b []byte) []byte {
+			return []byte(base64.StdEncoding.EncodeToString(b))
+		}, func(b []byte) []byte {
+			// every frame is encoded separately
+			var out []byte
+			for _, chunk := range strings.SplitAfter(string(b), "=") {
+				dec, _ := base64.StdEncoding.DecodeString(strings.TrimLeft(chunk, "="))
+				out = append(out, dec...)
+			}
+			return out
+		}},
+	}
+	for _, tt := range tests {
+		body := grpcWebFrame(t, &pingpong.PingRequest{Sender: "John"})
+		if tt.encode != nil {
+			body = tt.encode(body)
+		}
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/pingpong.PingPong/Ping", bytes.NewReader(body))
+		req.Header.Set("Content-Type", tt.contentType)
+		req.Header.Set("X-Grpc-Web", "1")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: could not call %v", tt.contentType, err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK || res.ProtoMajor != 1 {
+			t.Fatalf("%s: expected 200 via HTTP/1.1, got %d via %s", tt.contentType, res.StatusCode, res.Proto)
+		}
+		if tt.decode != nil {
+			b = tt.decode(b)
+		}
+
+		reply, trailer := readGrpcWebReply(t, b)
+		if reply.GetMessage() != "Hello John" {
+			t.Errorf("%s: expected Hello John, got %q", tt.contentType, reply.GetMessage())
+		}
+		if !strings.Contains(trailer, "grpc-status: 0") {
+			t.Errorf("%s: expected grpc-status 0, got %q", tt.contentType, trailer)
+		}
+	}
+}
+
+func TestGrpcWebCors(t *testing.T) {
+	s, ts := startGrpcWeb(t)
+	defer s.Shutdown(context.Background())
+	defer ts.Close()
+
+	for origin, allowed := range map[string]bool{
+		"https://example.com": true,
+		"https://evil.com":    false,
+	} {
+		req, _ := http.NewRequest(http.MethodOptions, ts.URL+"/pingpong.PingPong/Ping", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not send preflight %v", err)
+		}
+		res.Body.Close()
+
+		got := res.Header.Get("Access-Control-Allow-Origin")
+		if allowed && got != origin {
+			t.Errorf("expected %s to be allowed, got %q", origin, got)
+		}
+		if !allowed && got != "" {
+			t.Errorf("expected %s to be rejected, got %q", origin, got)
+		}
+	}
+
+	// REST routes are not affected
+	res, err := http.Get(ts.URL + SwaggerPath)
+	if err != nil {
+		t.Fatalf("could not get swagger %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", res.StatusCode)
+	}
+}
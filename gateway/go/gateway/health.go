@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// registerHealth adds the REST health routes. /healthz reports that the
+// process is alive, /readyz reports the GRPC serving status of the server or
+// of a single service via ?service=<name>.
+func registerHealth(mux *http.ServeMux, hs *health.Server) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, healthpb.HealthCheckResponse_SERVING.String())
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		res, err := hs.Check(r.Context(), &healthpb.HealthCheckRequest{
+			Service: r.URL.Query().Get("service"),
+		})
+		if status.Code(err) == codes.NotFound {
+			writeHealth(w, http.StatusNotFound, healthpb.HealthCheckResponse_SERVICE_UNKNOWN.String())
+			return
+		}
+		if err != nil {
+			writeHealth(w, http.StatusInternalServerError, healthpb.HealthCheckResponse_UNKNOWN.String())
+			return
+		}
+
+		code := http.StatusOK
+		if res.Status != healthpb.HealthCheckResponse_SERVING {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, code, res.Status.String())
+	})
+}
+
+func writeHealth(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
@@ -0,0 +1,121 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// readyz returns the status code and the reported status of /readyz
+func readyz(t *testing.T, url string) (int, string) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("could not call %s %v", url, err)
+	}
+	defer res.Body.Close()
+	var body map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode health %v", err)
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return res.StatusCode, body["status"]
+}
+
+func TestReadyzService(t *testing.T) {
+	s := startPingPong(t, Config{})
+	defer s.Shutdown(context.Background())
+	ts := httptest.NewServer(s.Admin)
+	defer ts.Close()
+
+	tests := []struct {
+		service string
+		code    int
+		status  string
+	}{
+		{"", http.StatusOK, "SERVING"},
+		{"pingpong.PingPong", http.StatusOK, "SERVING"},
+		{"unknown.Service", http.StatusNotFound, "SERVICE_UNKNOWN"},
+	}
+	for _, tt := range tests {
+		code, status := readyz(t, ts.URL+"/readyz?service="+tt.service)
+		if code != tt.code || status != tt.status {
+			t.Errorf("%q: expected %d %s, got %d %s", tt.service, tt.code, tt.status, code, status)
+		}
+	}
+
+	// the status of a single service does not change the others
+	s.Health.SetServingStatus("pingpong.PingPong", healthpb.HealthCheckResponse_NOT_SERVING)
+	if code, status := readyz(t, ts.URL+"/readyz?service=pingpong.PingPong"); code != http.StatusServiceUnavailable || status != "NOT_SERVING" {
+		t.Errorf("expected 503 NOT_SERVING, got %d %s", code, status)
+	}
+	if code, status := readyz(t, ts.URL+"/readyz"); code != http.StatusOK || status != "SERVING" {
+		t.Errorf("expected 200 SERVING, got %d %s", code, status)
+	}
+}
+
+func TestReadyzDuringShutdown(t *testing.T) {
+	port, adminPort := freePort(t), freePort(t)
+	s, err := NewServer(Config{Hostname: "localhost", Port: port, AdminPort: adminPort, Plaintext: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	err = s.Handle("/slow/", func(*Options) (*http.ServeMux, error) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		})
+		return mux, nil
+	})
+	if err != nil {
+		t.Fatalf("could not register route %v", err)
+	}
+	go s.Serve()
+	addr, adminAddr := fmt.Sprintf("localhost:%d", port), fmt.Sprintf("localhost:%d", adminPort)
+	waitListening(t, addr)
+	waitListening(t, adminAddr)
+
+	if code, status := readyz(t, "http://"+adminAddr+"/readyz"); code != http.StatusOK || status != "SERVING" {
+		t.Fatalf("expected 200 SERVING, got %d %s", code, status)
+	}
+
+	// the in-flight request keeps the server draining, the admin port is
+	// shut down last and reports it
+	done := make(chan struct{})
+	go func() {
+		if res, err := http.Get("http://" + addr + "/slow/"); err == nil {
+			res.Body.Close()
+		}
+		close(done)
+	}()
+	<-started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, status := readyz(t, "http://"+adminAddr+"/readyz")
+		if code == http.StatusServiceUnavailable && status == "NOT_SERVING" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 503 NOT_SERVING while draining, got %d %s", code, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	<-done
+	if err := <-shutdown; err != nil {
+		t.Errorf("could not shut down %v", err)
+	}
+}
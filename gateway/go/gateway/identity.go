@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerIdentity describes the verified client certificate of a mutual TLS connection
+type PeerIdentity struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	// SpiffeID is the first spiffe:// URI SAN of the certificate, if any
+	SpiffeID *url.URL
+}
+
+type peerIdentityKey struct{}
+
+// NewPeerIdentity extracts the identity from a client certificate
+func NewPeerIdentity(cert *x509.Certificate) *PeerIdentity {
+	id := &PeerIdentity{
+		Subject:        cert.Subject,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+	}
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			id.SpiffeID = uri
+			break
+		}
+	}
+	return id
+}
+
+// PeerIdentityFromContext returns the identity of the verified client
+// certificate. It is available for GRPC calls and REST routes added via Handle.
+func PeerIdentityFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	id, ok := ctx.Value(peerIdentityKey{}).(*PeerIdentity)
+	return id, ok
+}
+
+// contextWithPeerIdentity stores the identity of the verified client
+// certificate in the context, if the connection presented one
+func contextWithPeerIdentity(ctx context.Context, state *tls.ConnectionState) context.Context {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, peerIdentityKey{}, NewPeerIdentity(state.VerifiedChains[0][0]))
+}
+
+// grpcPeerContext attaches the peer identity of a GRPC call to its context
+func grpcPeerContext(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx
+	}
+	return contextWithPeerIdentity(ctx, &tlsInfo.State)
+}
+
+func peerIdentityUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(grpcPeerContext(ctx), req)
+}
+
+func peerIdentityStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &peerIdentityStream{ss, grpcPeerContext(ss.Context())})
+}
+
+// peerIdentityStream overrides the context of a server stream
+type peerIdentityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *peerIdentityStream) Context() context.Context {
+	return s.ctx
+}
+
+// peerIdentityHandler attaches the peer identity of REST requests to their context
+func peerIdentityHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			r = r.WithContext(contextWithPeerIdentity(r.Context(), r.TLS))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
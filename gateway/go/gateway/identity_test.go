@@ -0,0 +1,236 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCA issues certificates for the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	ca := &testCA{}
+	ca.pem, ca.key, ca.cert = createCert(t, template, nil, nil)
+	return ca
+}
+
+// issue signs a leaf certificate that is valid for server and client
+// authentication, it returns the certificate and the key as PEM
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) ([]byte, []byte) {
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	cert, key, _ := createCert(t, template, ca.cert, ca.key)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// createCert signs the template with the parent, or self-signs it without one
+func createCert(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, *ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key, cert
+}
+
+// clientTLS trusts the CA and presents the certificate, if one is given
+func clientTLS(t *testing.T, ca *testCA, cert, key []byte) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	if cert != nil {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg
+}
+
+// startMutualTLS runs the pingpong service with a /whoami/ route, both
+// report the peer identity of their callers
+func startMutualTLS(t *testing.T, ca *testCA, grpcPeers chan *PeerIdentity) (*Server, string) {
+	cert, key := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost"},
+	})
+	port := freePort(t)
+	s, err := NewServer(Config{
+		Hostname:   "localhost",
+		Port:       port,
+		Cert:       cert,
+		Key:        key,
+		CA:         ca.pem,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, WithUnaryInterceptors(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id, _ := PeerIdentityFromContext(ctx)
+		grpcPeers <- id
+		return handler(ctx, req)
+	}))
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	err = s.HandleGRPC(func(opts *Options, grpcServer *grpc.Server) error {
+		pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not register grpc service %v", err)
+	}
+	err = s.Handle("/whoami/", func(*Options) (*http.ServeMux, error) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			id, ok := PeerIdentityFromContext(r.Context())
+			if !ok {
+				http.Error(w, "no identity", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"cn":     id.Subject.CommonName,
+				"dns":    fmt.Sprint(id.DNSNames),
+				"spiffe": id.SpiffeID.String(),
+			})
+		})
+		return mux, nil
+	})
+	if err != nil {
+		t.Fatalf("could not register route %v", err)
+	}
+	go s.Serve()
+	addr := fmt.Sprintf("localhost:%d", port)
+	waitListening(t, addr)
+	return s, addr
+}
+
+func TestPeerIdentity(t *testing.T) {
+	ca := newTestCA(t, "gyrpsy CA")
+	grpcPeers := make(chan *PeerIdentity, 1)
+	s, addr := startMutualTLS(t, ca, grpcPeers)
+	defer s.Shutdown(context.Background())
+
+	spiffe, _ := url.Parse("spiffe://gyrpsy.local/client")
+	mail, _ := url.Parse("mailto:client@gyrpsy.local")
+	cert, key := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "client", Organization: []string{"gyrpsy"}},
+		DNSNames: []string{"client.gyrpsy.local"},
+		URIs:     []*url.URL{mail, spiffe},
+	})
+	cfg := clientTLS(t, ca, cert, key)
+
+	// GRPC
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := pingpong.NewPingPongClient(conn).Ping(context.Background(), &pingpong.PingRequest{Sender: "John"}); err != nil {
+		t.Fatalf("could not ping %v", err)
+	}
+	id := <-grpcPeers
+	if id == nil {
+		t.Fatal("expected the peer identity of the GRPC call")
+	}
+	if id.Subject.CommonName != "client" || len(id.Subject.Organization) != 1 || id.Subject.Organization[0] != "gyrpsy" {
+		t.Errorf("unexpected subject %v", id.Subject)
+	}
+	if len(id.DNSNames) != 1 || id.DNSNames[0] != "client.gyrpsy.local" || len(id.URIs) != 2 {
+		t.Errorf("unexpected SANs %v %v", id.DNSNames, id.URIs)
+	}
+	if id.SpiffeID == nil || id.SpiffeID.String() != "spiffe://gyrpsy.local/client" {
+		t.Errorf("expected the spiffe URI, got %v", id.SpiffeID)
+	}
+
+	// REST
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	res, err := client.Get("https://" + addr + "/whoami/")
+	if err != nil {
+		t.Fatalf("could not call /whoami/ %v", err)
+	}
+	defer res.Body.Close()
+	var body map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode identity %v", err)
+	}
+	if body["cn"] != "client" || body["dns"] != "[client.gyrpsy.local]" || body["spiffe"] != "spiffe://gyrpsy.local/client" {
+		t.Errorf("unexpected REST identity %v", body)
+	}
+}
+
+func TestClientAuthRejectsMissingCert(t *testing.T) {
+	ca := newTestCA(t, "gyrpsy CA")
+	s, addr := startMutualTLS(t, ca, make(chan *PeerIdentity, 1))
+	defer s.Shutdown(context.Background())
+	cfg := clientTLS(t, ca, nil, nil)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := pingpong.NewPingPongClient(conn).Ping(context.Background(), &pingpong.PingRequest{Sender: "John"}); err == nil {
+		t.Error("expected GRPC calls without client certificate to fail")
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	if res, err := client.Get("https://" + addr + "/whoami/"); err == nil {
+		res.Body.Close()
+		t.Errorf("expected REST calls without client certificate to fail, got %s", res.Status)
+	}
+
+	// a certificate of another CA is rejected as well
+	cert, key := newTestCA(t, "other CA").issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client"}})
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS(t, ca, cert, key)}}
+	if res, err := client.Get("https://" + addr + "/whoami/"); err == nil {
+		res.Body.Close()
+		t.Errorf("expected REST calls with a foreign client certificate to fail, got %s", res.Status)
+	}
+}
@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"net"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Option configures the GRPC server created by NewServer
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+	keepaliveParams    *keepalive.ServerParameters
+	keepalivePolicy    *keepalive.EnforcementPolicy
+	maxRecvMsgSize     int
+	maxSendMsgSize     int
+	creds              credentials.TransportCredentials
+	grpcOpts           []grpc.ServerOption
+	// unknownService proxies the calls of unregistered services
+	unknownService grpc.StreamHandler
+}
+
+// WithUnaryInterceptors adds unary interceptors to the chain. They run after
+// the built-in interceptors, in the order they are passed.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *serverOptions) {
+		o.unaryInterceptors = append(o.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors adds stream interceptors to the chain. They run after
+// the built-in interceptors, in the order they are passed.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *serverOptions) {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+	}
+}
+
+// WithKeepaliveParams sets the keepalive parameters of the GRPC server
+func WithKeepaliveParams(kp keepalive.ServerParameters) Option {
+	return func(o *serverOptions) {
+		o.keepaliveParams = &kp
+	}
+}
+
+// WithKeepaliveEnforcementPolicy sets the keepalive enforcement policy of the GRPC server
+func WithKeepaliveEnforcementPolicy(kep keepalive.EnforcementPolicy) Option {
+	return func(o *serverOptions) {
+		o.keepalivePolicy = &kep
+	}
+}
+
+// WithMaxRecvMsgSize sets the max message size in bytes the server can receive
+func WithMaxRecvMsgSize(size int) Option {
+	return func(o *serverOptions) {
+		o.maxRecvMsgSize = size
+	}
+}
+
+// WithMaxSendMsgSize sets the max message size in bytes the server can send
+func WithMaxSendMsgSize(size int) Option {
+	return func(o *serverOptions) {
+		o.maxSendMsgSize = size
+	}
+}
+
+// WithCreds replaces the default transport credentials of the GRPC server
+func WithCreds(creds credentials.TransportCredentials) Option {
+	return func(o *serverOptions) {
+		o.creds = creds
+	}
+}
+
+// WithGRPCServerOptions passes additional options to grpc.NewServer. Use the
+// dedicated options for interceptors and credentials, since grpc does not
+// allow to set them twice.
+func WithGRPCServerOptions(opts ...grpc.ServerOption) Option {
+	return func(o *serverOptions) {
+		o.grpcOpts = append(o.grpcOpts, opts...)
+	}
+}
+
+// grpcServerOptions merges the provided options with the defaults
+func (o *serverOptions) grpcServerOptions() []grpc.ServerOption {
+	unary := append([]grpc.UnaryServerInterceptor{
+		grpc_prometheus.UnaryServerInterceptor,
+		peerIdentityUnaryInterceptor,
+	}, o.unaryInterceptors...)
+	stream := append([]grpc.StreamServerInterceptor{
+		grpc_prometheus.StreamServerInterceptor,
+		peerIdentityStreamInterceptor,
+	}, o.streamInterceptors...)
+
+	opts := []grpc.ServerOption{
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(stream...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unary...)),
+	}
+	if o.creds != nil {
+		opts = append(opts, grpc.Creds(inProcessCreds{o.creds}))
+	}
+	if o.unknownService != nil {
+		opts = append(opts, grpc.ForceServerCodec(proxyCodec{}), grpc.UnknownServiceHandler(o.unknownService))
+	}
+	if o.keepaliveParams != nil {
+		opts = append(opts, grpc.KeepaliveParams(*o.keepaliveParams))
+	}
+	if o.keepalivePolicy != nil {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(*o.keepalivePolicy))
+	}
+	if o.maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(o.maxRecvMsgSize))
+	}
+	if o.maxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(o.maxSendMsgSize))
+	}
+	return append(opts, o.grpcOpts...)
+}
+
+// inProcessCreds skips the TLS handshake for the in-process connection of
+// Options.Conn, all other connections use the wrapped credentials
+type inProcessCreds struct {
+	credentials.TransportCredentials
+}
+
+// inProcessAuthInfo identifies connections from Options.Conn
+type inProcessAuthInfo struct{}
+
+func (inProcessAuthInfo) AuthType() string {
+	return "inprocess"
+}
+
+func (c inProcessCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if conn.LocalAddr().Network() == "bufconn" {
+		return conn, inProcessAuthInfo{}, nil
+	}
+	return c.TransportCredentials.ServerHandshake(conn)
+}
+
+func (c inProcessCreds) Clone() credentials.TransportCredentials {
+	return inProcessCreds{c.TransportCredentials.Clone()}
+}
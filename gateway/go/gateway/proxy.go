@@ -0,0 +1,194 @@
+package gateway
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// frame is a message that is forwarded without unmarshalling it
+type frame struct {
+	payload []byte
+}
+
+// proxyCodec passes frames as they are and handles all other messages like
+// the default proto codec. It keeps the name proto, so that clients and
+// upstreams see the usual content type.
+type proxyCodec struct{}
+
+func (proxyCodec) Marshal(v interface{}) ([]byte, error) {
+	if f, ok := v.(*frame); ok {
+		return f.payload, nil
+	}
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("proxy codec: cannot marshal %T", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (proxyCodec) Unmarshal(data []byte, v interface{}) error {
+	if f, ok := v.(*frame); ok {
+		// the buffer of data is reused after Unmarshal returns
+		f.payload = append([]byte(nil), data...)
+		return nil
+	}
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return errors.Errorf("proxy codec: cannot unmarshal %T", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func (proxyCodec) Name() string {
+	return "proto"
+}
+
+// proxyStreamDesc fits every kind of method, unary calls are streams with a
+// single message in each direction
+var proxyStreamDesc = &grpc.StreamDesc{
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// proxyRoute forwards all methods starting with prefix to the upstream
+type proxyRoute struct {
+	prefix   string
+	upstream string
+	conn     *grpc.ClientConn
+}
+
+// HandleProxy forwards the GRPC methods starting with prefix to the backends,
+// like grpc_pass of nginx. The prefix is a service, eg. /pingpong.PingPong/,
+// or a package, eg. /pingpong. The longest matching prefix wins, services
+// registered via HandleGRPC are served locally. Requests and responses are
+// forwarded as raw frames, no generated code of the services is needed.
+// The upstreams are dialed with Backends.Dopts only, eg. their credentials.
+// It requires Config.GrpcProxy.
+func (s *Server) HandleProxy(prefix string, backends *Backends) error {
+	err := s.handleProxy(prefix, backends)
+	if err != nil {
+		s.registrationFailed(err)
+	}
+	return err
+}
+
+func (s *Server) handleProxy(prefix string, backends *Backends) error {
+	if !s.config.GrpcProxy {
+		return errors.Errorf("register proxy %s: Config.GrpcProxy is disabled", prefix)
+	}
+	if !strings.HasPrefix(prefix, "/") {
+		return errors.Errorf("register proxy %q: prefix needs to start with /", prefix)
+	}
+
+	s.mu.Lock()
+	for _, r := range s.proxies {
+		if r.prefix == prefix {
+			s.mu.Unlock()
+			return errors.Errorf("register proxy %s: prefix is already registered", prefix)
+		}
+	}
+	s.mu.Unlock()
+
+	// the upstreams accept the messages of the size the GRPC server accepts
+	// from its clients. The dial options of the gateway clients are not
+	// used, the upstreams are no gateway and have their own credentials.
+	copts := append(append([]grpc.CallOption{}, s.copts...), grpc.ForceCodec(proxyCodec{}))
+	conn, err := backends.Dial(s.config.CallPolicies, grpc.WithDefaultCallOptions(copts...))
+	if err != nil {
+		return errors.Wrapf(err, "register proxy %s", prefix)
+	}
+
+	s.mu.Lock()
+	s.proxies = append(s.proxies, &proxyRoute{prefix: prefix, upstream: backends.String(), conn: conn})
+	sort.Slice(s.proxies, func(i, j int) bool {
+		return len(s.proxies[i].prefix) > len(s.proxies[j].prefix)
+	})
+	s.mu.Unlock()
+
+	logrus.Infof("register proxy %s", prefix)
+	return nil
+}
+
+// proxyConn returns the upstream of the longest prefix of the method
+func (s *Server) proxyConn(method string) *grpc.ClientConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.proxies {
+		if strings.HasPrefix(method, r.prefix) {
+			return r.conn
+		}
+	}
+	return nil
+}
+
+// proxyStream is the handler of unknown services. It forwards the call with
+// its metadata and deadline to the upstream and relays the responses, the
+// header, the trailer and the status.
+func (s *Server) proxyStream(srv interface{}, stream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(stream)
+	if !ok {
+		return status.Error(codes.Internal, "proxy: unknown method")
+	}
+	conn := s.proxyConn(method)
+	if conn == nil {
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	md, _ := metadata.FromIncomingContext(ctx)
+	upstream, err := conn.NewStream(metadata.NewOutgoingContext(ctx, md.Copy()), proxyStreamDesc, method)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			f := &frame{}
+			if err := stream.RecvMsg(f); err != nil {
+				if err == io.EOF {
+					upstream.CloseSend()
+				} else {
+					// the client is gone, abort the upstream call
+					cancel()
+				}
+				return
+			}
+			// the upstream reports its failure via RecvMsg
+			if err := upstream.SendMsg(f); err != nil {
+				return
+			}
+		}
+	}()
+
+	// the header is missing if the upstream fails right away, the status
+	// is then sent as trailers only
+	if header, err := upstream.Header(); err == nil && len(header) > 0 {
+		if err := stream.SendHeader(header); err != nil {
+			return err
+		}
+	}
+	for {
+		f := &frame{}
+		if err := upstream.RecvMsg(f); err != nil {
+			stream.SetTrailer(upstream.Trailer())
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := stream.SendMsg(f); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package gateway
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// upstream is a pingpong server without the gateway. It echoes the
+// x-request-id metadata as header and trailer and fails the pings of "fail".
+type upstream struct {
+	addr   string
+	server *grpc.Server
+	calls  int32
+}
+
+func startUpstream(t *testing.T) *upstream {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &upstream{addr: lis.Addr().String()}
+	u.server = grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			atomic.AddInt32(&u.calls, 1)
+			md, _ := metadata.FromIncomingContext(ctx)
+			if ids := md["x-request-id"]; len(ids) > 0 {
+				grpc.SetHeader(ctx, metadata.Pairs("x-request-id", ids[0]))
+				grpc.SetTrailer(ctx, metadata.Pairs("x-request-id", ids[0]))
+			}
+			if r, ok := req.(*pingpong.PingRequest); ok && r.Sender == "fail" {
+				return nil, status.Error(codes.NotFound, "no such sender")
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			atomic.AddInt32(&u.calls, 1)
+			return handler(srv, ss)
+		}),
+	)
+	pingpong.RegisterPingPongServer(u.server, &pingpong.PingPongServerImpl{})
+	go u.server.Serve(lis)
+	return u
+}
+
+// startProxy runs a gateway without services, which proxies the routes to
+// the upstreams
+func startProxy(t *testing.T, routes map[string]*upstream) *Server {
+	s, err := NewServer(Config{Hostname: "localhost", Plaintext: true, GrpcProxy: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	for prefix, u := range routes {
+		backends := &Backends{Addrs: []string{u.addr}, Dopts: []grpc.DialOption{grpc.WithInsecure()}}
+		if err := s.HandleProxy(prefix, backends); err != nil {
+			t.Fatalf("could not register proxy %v", err)
+		}
+	}
+	go s.Serve()
+	return s
+}
+
+func TestProxyUnary(t *testing.T) {
+	u := startUpstream(t)
+	defer u.server.Stop()
+	s := startProxy(t, map[string]*upstream{"/pingpong.PingPong/": u})
+	defer s.Shutdown(context.Background())
+	client := pingpong.NewPingPongClient(s.Options.Conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "42")
+	var header, trailer metadata.MD
+	res, err := client.Ping(ctx, &pingpong.PingRequest{Sender: "John"}, grpc.WaitForReady(true), grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatalf("could not ping %v", err)
+	}
+	if res.Message != "Hello John" {
+		t.Errorf("unexpected response %q", res.Message)
+	}
+	if id := header["x-request-id"]; len(id) != 1 || id[0] != "42" {
+		t.Errorf("expected the header of the upstream, got %v", header)
+	}
+	if id := trailer["x-request-id"]; len(id) != 1 || id[0] != "42" {
+		t.Errorf("expected the trailer of the upstream, got %v", trailer)
+	}
+
+	// the status of the upstream is passed through
+	_, err = client.Ping(ctx, &pingpong.PingRequest{Sender: "fail"}, grpc.Trailer(&trailer))
+	if st, _ := status.FromError(err); st.Code() != codes.NotFound || st.Message() != "no such sender" {
+		t.Errorf("expected the status of the upstream, got %v", err)
+	}
+	if id := trailer["x-request-id"]; len(id) != 1 || id[0] != "42" {
+		t.Errorf("expected the trailer of the failed call, got %v", trailer)
+	}
+}
+
+func TestProxyStreams(t *testing.T) {
+	u := startUpstream(t)
+	defer u.server.Stop()
+	s := startProxy(t, map[string]*upstream{"/pingpong.PingPong/": u})
+	defer s.Shutdown(context.Background())
+	client := pingpong.NewPingPongClient(s.Options.Conn)
+	ctx := context.Background()
+
+	// server streaming
+	stream, err := client.PingStream(ctx, &pingpong.PingStreamRequest{Sender: "John", Count: 3}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("could not stream %v", err)
+	}
+	n := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not receive %v", err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("expected 3 responses, got %d", n)
+	}
+
+	// client streaming
+	collect, err := client.PingCollect(ctx)
+	if err != nil {
+		t.Fatalf("could not stream %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := collect.Send(&pingpong.PingRequest{Sender: "John"}); err != nil {
+			t.Fatalf("could not send %v", err)
+		}
+	}
+	res, err := collect.CloseAndRecv()
+	if err != nil || res.Message != "Hello 3 pings" {
+		t.Errorf("unexpected response %v %v", res, err)
+	}
+
+	// bidirectional streaming, every ping is answered before the next one
+	bidi, err := client.PingPongStream(ctx)
+	if err != nil {
+		t.Fatalf("could not stream %v", err)
+	}
+	for _, sender := range []string{"John", "Jane"} {
+		if err := bidi.Send(&pingpong.PingRequest{Sender: sender}); err != nil {
+			t.Fatalf("could not send %v", err)
+		}
+		res, err := bidi.Recv()
+		if err != nil || res.Message != "Hello "+sender {
+			t.Errorf("unexpected response %v %v", res, err)
+		}
+	}
+	bidi.CloseSend()
+	if _, err := bidi.Recv(); err != io.EOF {
+		t.Errorf("expected the end of the stream, got %v", err)
+	}
+}
+
+func TestProxyRoutes(t *testing.T) {
+	pkg, service := startUpstream(t), startUpstream(t)
+	defer pkg.server.Stop()
+	defer service.server.Stop()
+	s := startProxy(t, map[string]*upstream{
+		"/pingpong.":          pkg,
+		"/pingpong.PingPong/": service,
+	})
+	defer s.Shutdown(context.Background())
+
+	proxies := s.Routes().Proxies
+	expected := []ProxyRoute{
+		{Prefix: "/pingpong.", Upstream: pkg.addr},
+		{Prefix: "/pingpong.PingPong/", Upstream: service.addr},
+	}
+	if len(proxies) != len(expected) || proxies[0] != expected[0] || proxies[1] != expected[1] {
+		t.Errorf("expected proxies %v, got %v", expected, proxies)
+	}
+
+	// the longest prefix wins
+	_, err := pingpong.NewPingPongClient(s.Options.Conn).Ping(context.Background(), &pingpong.PingRequest{Sender: "John"}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatalf("could not ping %v", err)
+	}
+	if atomic.LoadInt32(&service.calls) != 1 || atomic.LoadInt32(&pkg.calls) != 0 {
+		t.Errorf("expected the call to the service upstream, got %d and %d calls", service.calls, pkg.calls)
+	}
+
+	// the package upstream does not know the service
+	err = s.Options.Conn.Invoke(context.Background(), "/pingpong.Other/Ping", &pingpong.PingRequest{}, &pingpong.PongReply{})
+	if status.Code(err) != codes.Unimplemented || atomic.LoadInt32(&pkg.calls) != 0 {
+		t.Errorf("expected unimplemented from the package upstream, got %v", err)
+	}
+
+	// methods without route are unimplemented
+	err = s.Options.Conn.Invoke(context.Background(), "/other.Service/Ping", &pingpong.PingRequest{}, &pingpong.PongReply{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected unimplemented, got %v", err)
+	}
+
+	// registered services are served locally
+	if _, err := healthpb.NewHealthClient(s.Options.Conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("could not check health %v", err)
+	}
+}
+
+func TestHandleProxyRejectsInvalidRoutes(t *testing.T) {
+	s, err := NewServer(Config{Hostname: "localhost", Plaintext: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	backends := &Backends{Addrs: []string{"localhost:5001"}, Dopts: []grpc.DialOption{grpc.WithInsecure()}}
+	if err := s.HandleProxy("/pingpong.PingPong/", backends); err == nil {
+		t.Errorf("expected an error without Config.GrpcProxy")
+	}
+
+	s, err = NewServer(Config{Hostname: "localhost", Plaintext: true, GrpcProxy: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	if err := s.HandleProxy("/pingpong.PingPong/", backends); err != nil {
+		t.Fatalf("could not register proxy %v", err)
+	}
+	for _, prefix := range []string{"pingpong.PingPong/", "/pingpong.PingPong/"} {
+		if err := s.HandleProxy(prefix, backends); err == nil {
+			t.Errorf("expected an error for %q", prefix)
+		}
+	}
+	if err := s.Serve(); err == nil {
+		t.Errorf("expected Serve to refuse failed registrations")
+	}
+}
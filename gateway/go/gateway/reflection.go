@@ -0,0 +1,152 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// reflectionRegistry lists only the allowed services via reflection. Without
+// an allow-list, all services are listed.
+type reflectionRegistry struct {
+	*grpc.Server
+	allowed map[string]bool
+}
+
+func (r *reflectionRegistry) GetServiceInfo() map[string]grpc.ServiceInfo {
+	info := r.Server.GetServiceInfo()
+	if len(r.allowed) == 0 {
+		return info
+	}
+
+	filtered := map[string]grpc.ServiceInfo{}
+	for name, si := range info {
+		if r.allowed[name] {
+			filtered[name] = si
+		}
+	}
+	return filtered
+}
+
+// registerReflection adds the GRPC server reflection service
+func registerReflection(s *grpc.Server, services []string) {
+	reflection.Register(&reflectionRegistry{
+		Server:  s,
+		allowed: allowedServices(services),
+	})
+}
+
+func allowedServices(services []string) map[string]bool {
+	allowed := map[string]bool{}
+	for _, name := range services {
+		allowed[name] = true
+	}
+	return allowed
+}
+
+// reflectionInterceptor hides the services that are not on the allow-list
+// from the file descriptors of the reflection service. The reflection
+// service only filters the list of services, files and symbols are resolved
+// for all services.
+func reflectionInterceptor(services []string) grpc.StreamServerInterceptor {
+	allowed := allowedServices(services)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		// both the v1alpha and the v1 reflection service
+		if !strings.HasPrefix(info.FullMethod, "/grpc.reflection.") || !strings.HasSuffix(info.FullMethod, ".ServerReflection/ServerReflectionInfo") {
+			return handler(srv, ss)
+		}
+		return handler(srv, &reflectionStream{ServerStream: ss, allowed: allowed})
+	}
+}
+
+// reflectionStream filters the responses of the reflection service. The
+// messages of all versions of the service share their field names.
+type reflectionStream struct {
+	grpc.ServerStream
+	allowed map[string]bool
+	// symbol is requested by the current request, if any
+	symbol string
+}
+
+func (s *reflectionStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	s.symbol = ""
+	if msg, ok := m.(proto.Message); ok {
+		req := proto.MessageReflect(msg)
+		if field := req.Descriptor().Fields().ByName("file_containing_symbol"); field != nil && req.Has(field) {
+			s.symbol = req.Get(field).String()
+		}
+	}
+	return nil
+}
+
+func (s *reflectionStream) SendMsg(m interface{}) error {
+	if msg, ok := m.(proto.Message); ok {
+		if err := s.filter(proto.MessageReflect(msg)); err != nil {
+			return err
+		}
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// filter removes the hidden services from the file descriptors of the
+// response. A request for a hidden service or method is answered with
+// NOT_FOUND, like an unknown symbol.
+func (s *reflectionStream) filter(res protoreflect.Message) error {
+	fields := res.Descriptor().Fields()
+	filesField := fields.ByName("file_descriptor_response")
+	if filesField == nil || !res.Has(filesField) {
+		return nil
+	}
+	files := res.Mutable(filesField).Message()
+	list := files.Mutable(files.Descriptor().Fields().ByName("file_descriptor_proto")).List()
+
+	hidden := false
+	for i := 0; i < list.Len(); i++ {
+		fd := &descpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(list.Get(i).Bytes(), fd); err != nil {
+			return status.Errorf(codes.Internal, "reflection: could not parse file descriptor: %v", err)
+		}
+		var services []*descpb.ServiceDescriptorProto
+		for _, svc := range fd.GetService() {
+			name := svc.GetName()
+			if fd.GetPackage() != "" {
+				name = fd.GetPackage() + "." + name
+			}
+			if s.allowed[name] {
+				services = append(services, svc)
+				continue
+			}
+			if s.symbol == name || strings.HasPrefix(s.symbol, name+".") {
+				hidden = true
+			}
+		}
+		if len(services) == len(fd.GetService()) {
+			continue
+		}
+		fd.Service = services
+		data, err := proto.Marshal(fd)
+		if err != nil {
+			return status.Errorf(codes.Internal, "reflection: could not marshal file descriptor: %v", err)
+		}
+		list.Set(i, protoreflect.ValueOfBytes(data))
+	}
+
+	if hidden {
+		errField := fields.ByName("error_response")
+		errRes := res.NewField(errField).Message()
+		errFields := errRes.Descriptor().Fields()
+		errRes.Set(errFields.ByName("error_code"), protoreflect.ValueOfInt32(int32(codes.NotFound)))
+		errRes.Set(errFields.ByName("error_message"), protoreflect.ValueOfString("symbol not found: "+s.symbol))
+		res.Set(errField, protoreflect.ValueOfMessage(errRes))
+	}
+	return nil
+}
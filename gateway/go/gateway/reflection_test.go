@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// reflect sends a single request to the reflection service
+func reflect(t *testing.T, s *Server, req *rpb.ServerReflectionRequest) *rpb.ServerReflectionResponse {
+	stream, err := rpb.NewServerReflectionClient(s.Options.Conn).ServerReflectionInfo(context.Background(), grpc.FailFast(false))
+	if err != nil {
+		t.Fatalf("could not open reflection stream %v", err)
+	}
+	if err := stream.Send(req); err != nil {
+		t.Fatalf("could not send reflection request %v", err)
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("could not receive reflection response %v", err)
+	}
+	stream.CloseSend()
+	return res
+}
+
+func listServices(t *testing.T, s *Server) []string {
+	res := reflect(t, s, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	var names []string
+	for _, svc := range res.GetListServicesResponse().GetService() {
+		names = append(names, svc.GetName())
+	}
+	return names
+}
+
+func TestReflectionAllowList(t *testing.T) {
+	s := startPingPong(t, Config{
+		Reflection:         true,
+		ReflectionServices: []string{"pingpong.PingPong"},
+	})
+	defer s.Shutdown(context.Background())
+
+	names := listServices(t, s)
+	if len(names) != 1 || names[0] != "pingpong.PingPong" {
+		t.Fatalf("expected only pingpong.PingPong, got %v", names)
+	}
+
+	res := reflect(t, s, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: "pingpong.PingPong",
+		},
+	})
+	files := res.GetFileDescriptorResponse().GetFileDescriptorProto()
+	if len(files) == 0 {
+		t.Fatalf("could not resolve pingpong.PingPong: %v", res.GetErrorResponse())
+	}
+	fd := &descpb.FileDescriptorProto{}
+	if err := proto.Unmarshal(files[0], fd); err != nil {
+		t.Fatalf("could not parse file descriptor %v", err)
+	}
+	if fd.GetName() != "pingpong.proto" || len(fd.GetService()) != 1 || fd.GetService()[0].GetName() != "PingPong" {
+		t.Fatalf("unexpected file descriptor %s with services %v", fd.GetName(), fd.GetService())
+	}
+}
+
+func TestReflectionAllServices(t *testing.T) {
+	s := startPingPong(t, Config{
+		Reflection: true,
+	})
+	defer s.Shutdown(context.Background())
+
+	listed := map[string]bool{}
+	for _, name := range listServices(t, s) {
+		listed[name] = true
+	}
+	for _, name := range []string{"pingpong.PingPong", "grpc.health.v1.Health"} {
+		if !listed[name] {
+			t.Errorf("expected %s to be listed, got %v", name, listed)
+		}
+	}
+}
+
+func TestReflectionHidesServices(t *testing.T) {
+	s := startPingPong(t, Config{
+		Reflection:         true,
+		ReflectionServices: []string{"pingpong.PingPong"},
+	})
+	defer s.Shutdown(context.Background())
+
+	for _, symbol := range []string{"grpc.health.v1.Health", "grpc.health.v1.Health.Check"} {
+		res := reflect(t, s, &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{
+				FileContainingSymbol: symbol,
+			},
+		})
+		if code := res.GetErrorResponse().GetErrorCode(); code != int32(codes.NotFound) || len(res.GetFileDescriptorResponse().GetFileDescriptorProto()) != 0 {
+			t.Errorf("expected %s to be hidden, got %v", symbol, res)
+		}
+	}
+
+	// the messages of the file are still resolvable, its services are not
+	res := reflect(t, s, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{
+			FileByFilename: "grpc/health/v1/health.proto",
+		},
+	})
+	files := res.GetFileDescriptorResponse().GetFileDescriptorProto()
+	if len(files) == 0 {
+		t.Fatalf("could not resolve health.proto: %v", res.GetErrorResponse())
+	}
+	fd := &descpb.FileDescriptorProto{}
+	if err := proto.Unmarshal(files[0], fd); err != nil {
+		t.Fatalf("could not parse file descriptor %v", err)
+	}
+	if len(fd.GetService()) != 0 || len(fd.GetMessageType()) == 0 {
+		t.Errorf("expected health.proto without services, got %v and %d messages", fd.GetService(), len(fd.GetMessageType()))
+	}
+}
@@ -0,0 +1,204 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/protobuf/proto"
+	descpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/api/annotations"
+)
+
+// Routes describes everything a server serves
+type Routes struct {
+	// REST lists the registered route prefixes
+	REST []string `json:"rest"`
+	// GRPC lists the registered services and their methods
+	GRPC []GRPCService `json:"grpc"`
+	// Bindings lists the HTTP bindings of the GRPC gateway routes
+	Bindings []HTTPBinding `json:"bindings"`
+	// Proxies lists the GRPC method prefixes registered via HandleProxy
+	Proxies []ProxyRoute `json:"proxies"`
+}
+
+type GRPCService struct {
+	Name    string       `json:"name"`
+	Methods []GRPCMethod `json:"methods"`
+}
+
+type GRPCMethod struct {
+	Name            string `json:"name"`
+	ClientStreaming bool   `json:"clientStreaming"`
+	ServerStreaming bool   `json:"serverStreaming"`
+}
+
+// ProxyRoute forwards the GRPC methods starting with Prefix to the Upstream
+// backends, eg. /pingpong.PingPong/ to localhost:5001
+type ProxyRoute struct {
+	Prefix   string `json:"prefix"`
+	Upstream string `json:"upstream"`
+}
+
+// HTTPBinding maps a REST path to a GRPC method, eg. POST /pingpong/ping to
+// pingpong.PingPong.Ping
+type HTTPBinding struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	RPC    string `json:"rpc"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Routes lists the registered REST prefixes, GRPC services, the HTTP
+// bindings of all routes registered via HandleGateway and the proxies. Like reflection, it
+// only lists the services of Config.ReflectionServices if they are set.
+func (s *Server) Routes() *Routes {
+	routes := &Routes{
+		REST:     []string{},
+		GRPC:     []GRPCService{},
+		Bindings: []HTTPBinding{},
+		Proxies:  []ProxyRoute{},
+	}
+
+	s.mu.Lock()
+	gateways := map[string][]string{}
+	for pattern, services := range s.routes {
+		routes.REST = append(routes.REST, pattern)
+		gateways[pattern] = services
+	}
+	for _, r := range s.proxies {
+		routes.Proxies = append(routes.Proxies, ProxyRoute{Prefix: r.prefix, Upstream: r.upstream})
+	}
+	s.mu.Unlock()
+	sort.Strings(routes.REST)
+	sort.Slice(routes.Proxies, func(i, j int) bool { return routes.Proxies[i].Prefix < routes.Proxies[j].Prefix })
+
+	info := s.GRPC.GetServiceInfo()
+	if len(s.config.ReflectionServices) > 0 {
+		info = (&reflectionRegistry{Server: s.GRPC, allowed: allowedServices(s.config.ReflectionServices)}).GetServiceInfo()
+	}
+	names := make([]string, 0, len(info))
+	for name := range info {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		svc := GRPCService{Name: name, Methods: []GRPCMethod{}}
+		for _, m := range info[name].Methods {
+			svc.Methods = append(svc.Methods, GRPCMethod{
+				Name:            m.Name,
+				ClientStreaming: m.IsClientStream,
+				ServerStreaming: m.IsServerStream,
+			})
+		}
+		sort.Slice(svc.Methods, func(i, j int) bool { return svc.Methods[i].Name < svc.Methods[j].Name })
+		routes.GRPC = append(routes.GRPC, svc)
+	}
+
+	for _, pattern := range routes.REST {
+		for _, name := range gateways[pattern] {
+			// the info of hidden services is missing
+			file, ok := info[name].Metadata.(string)
+			if !ok {
+				continue
+			}
+			bindings, err := httpBindings(file, name, strings.TrimSuffix(pattern, "/"))
+			if err != nil {
+				logrus.Warnf("cannot list bindings of %s: %v", name, err)
+				continue
+			}
+			routes.Bindings = append(routes.Bindings, bindings...)
+		}
+	}
+	return routes
+}
+
+// httpBindings reads the google.api.http options of the service from the
+// registered proto file descriptor
+func httpBindings(file string, service string, prefix string) ([]HTTPBinding, error) {
+	gz := proto.FileDescriptor(file)
+	if gz == nil {
+		return nil, errors.Errorf("file descriptor %s is not registered", file)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, errors.Wrapf(err, "decompress %s", file)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decompress %s", file)
+	}
+	fd := &descpb.FileDescriptorProto{}
+	if err := proto.Unmarshal(b, fd); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", file)
+	}
+
+	var bindings []HTTPBinding
+	for _, sd := range fd.GetService() {
+		name := sd.GetName()
+		if fd.GetPackage() != "" {
+			name = fd.GetPackage() + "." + name
+		}
+		if name != service {
+			continue
+		}
+
+		for _, md := range sd.GetMethod() {
+			if md.GetOptions() == nil || !proto.HasExtension(md.GetOptions(), annotations.E_Http) {
+				continue
+			}
+			ext, err := proto.GetExtension(md.GetOptions(), annotations.E_Http)
+			if err != nil {
+				return nil, errors.Wrapf(err, "read http option of %s.%s", name, md.GetName())
+			}
+			rule := ext.(*annotations.HttpRule)
+			rpc := name + "." + md.GetName()
+			for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+				method, path := httpRule(r)
+				if method == "" {
+					continue
+				}
+				bindings = append(bindings, HTTPBinding{
+					Method: method,
+					Path:   prefix + path,
+					RPC:    rpc,
+					Body:   r.GetBody(),
+				})
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// httpRule returns the http method and path of the rule
+func httpRule(r *annotations.HttpRule) (string, string) {
+	switch p := r.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		return p.Custom.GetKind(), p.Custom.GetPath()
+	}
+	return "", ""
+}
+
+// registerRoutes adds the admin route that lists all routes as JSON
+func registerRoutes(mux *http.ServeMux, s *Server) {
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Routes())
+	})
+}
@@ -3,35 +3,104 @@ package gateway
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/context"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
 )
 
+// bufconnSize is the buffer size of the in-process GRPC connection
+const bufconnSize = 1024 * 1024
+
 type Config struct {
 	Hostname string
 	Port     int
 	Key      []byte
 	Cert     []byte
+	// CA is an optional PEM bundle of root certificates. If it is empty,
+	// Cert is used as its own CA.
+	CA []byte
+	// CertProvider allows to rotate certificates at runtime. If it is set,
+	// Key, Cert and CA are ignored.
+	CertProvider *CertProvider
+	// Plaintext serves GRPC and REST over cleartext HTTP/2 (h2c) and HTTP/1.1
+	// on the same port. No certificates are required in this mode.
+	Plaintext bool
+	// ClientAuth enables mutual TLS. Client certificates are verified against
+	// the CA pool. The REST gateway authenticates with Cert against the GRPC
+	// endpoint, therefore Cert needs to be valid for client authentication too.
+	ClientAuth tls.ClientAuthType
+	// GrpcPort, RestPort and AdminPort move GRPC, REST and the admin routes
+	// (metrics, pprof) to separate listeners. GRPC is served natively by
+	// grpc.Server instead of the http bridge. Everything without a dedicated
+	// port stays on the shared Port.
+	GrpcPort  int
+	RestPort  int
+	AdminPort int
+	// Reflection registers the GRPC server reflection service for tools like
+	// grpcurl. ReflectionServices limits the listed and resolvable services
+	// to the given names, all services are exposed if it is empty. The
+	// /routes admin route lists the same services.
+	Reflection         bool
+	ReflectionServices []string
+	// GrpcWeb serves grpc-web and grpc-web-text requests of browsers on the
+	// listener of the REST routes, over HTTP/1.1 as well. GrpcWebOrigins lists
+	// the origins that are allowed via CORS, "*" allows all. Without origins,
+	// browsers may only call from the same origin. The origins apply to the
+	// websockets of StreamBridge as well.
+	GrpcWeb        bool
+	GrpcWebOrigins []string
+	// MaxRecvMsgSize limits the size of requests and MaxSendMsgSize the size
+	// of responses in bytes. They apply to the GRPC server and to the
+	// connections the REST gateway uses to call it. The GRPC defaults are
+	// used if they are 0.
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+	// CallPolicies set the timeouts, retries and hedging of the calls of the
+	// REST gateway via Options.Dopts and Options.Conn
+	CallPolicies []CallPolicy
+	// Backends are external GRPC servers the REST gateway balances its calls
+	// across via Options.Backend, eg. several pp_grpc instances
+	Backends *Backends
+	// GrpcProxy forwards the calls of services that are not registered via
+	// HandleGRPC to upstreams, see HandleProxy. It replaces the codec of the
+	// GRPC server with one that passes raw frames.
+	GrpcProxy bool
 }
 
 type Options struct {
 	GrpcAddr string
 	Dopts    []grpc.DialOption
+	// Conn is an in-process connection to the GRPC server. REST handlers use
+	// it to skip the TLS handshake and the network hop to GrpcAddr.
+	Conn *grpc.ClientConn
+	// Backend is a balanced connection to Config.Backends, it is nil if no
+	// backends are configured
+	Backend *grpc.ClientConn
+	// allowOrigin checks the origins of browsers, see Config.GrpcWebOrigins
+	allowOrigin func(string) bool
 }
 
 type tlsConfig struct {
-	KeyPair  *tls.Certificate
-	CertPool *x509.CertPool
+	Provider   *CertProvider
+	ClientAuth tls.ClientAuthType
 }
 
 type Server struct {
@@ -39,70 +108,206 @@ type Server struct {
 	GRPC    *grpc.Server
 	Options *Options
 	Mux     *http.ServeMux
-	tls     *tlsConfig
-}
+	// Admin holds the routes for operators, like metrics
+	Admin *http.ServeMux
+	// Health reports the serving status of all services registered via
+	// HandleGRPC, both via GRPC and the REST health routes
+	Health *health.Server
+	tls    *tlsConfig
+	addr   string
+	// bufLis connects Options.Conn to the GRPC server in memory
+	bufLis *bufconn.Listener
+	// grpcWeb translates grpc-web requests, it is nil if disabled
+	grpcWeb *grpcweb.WrappedGrpcServer
+	// copts mirror the message size limits of the GRPC server for its clients
+	copts []grpc.CallOption
 
-func LoadCert(cert []byte, key []byte) (*tls.Certificate, *x509.CertPool) {
-	var err error
+	mu       sync.Mutex
+	servers  []*http.Server
+	inflight int
+	// shutdown is set once Shutdown started, Serve does not start afterwards
+	shutdown bool
+	// routes maps the registered prefixes to the GRPC services they expose
+	// via the GRPC gateway
+	routes           map[string][]string
+	proxies          []*proxyRoute
+	swagger          swaggerDoc
+	registrationErrs []error
+}
 
+// LoadCert parses the key pair and builds the pool of trusted root certificates.
+// The pool contains all certificates of the provided CA bundles. Without a
+// bundle, the certificate is expected to be self-signed and acts as its own CA.
+func LoadCert(cert []byte, key []byte, ca ...[]byte) (*tls.Certificate, *x509.CertPool, error) {
 	pair, err := tls.X509KeyPair(cert, key)
 	if err != nil {
-		logrus.Fatal(err)
+		return nil, nil, errors.Wrap(err, "load key pair")
+	}
+
+	if len(ca) == 0 {
+		ca = [][]byte{cert}
 	}
-	keyPair := &pair
 	certPool := x509.NewCertPool()
-	ok := certPool.AppendCertsFromPEM([]byte(cert))
-	if !ok {
-		logrus.Fatal(err)
+	for _, bundle := range ca {
+		if ok := certPool.AppendCertsFromPEM(bundle); !ok {
+			return nil, nil, errors.New("load CA: no valid PEM certificate found")
+		}
 	}
-	return keyPair, certPool
+	return &pair, certPool, nil
 }
 
-func NewServer(config Config, opt ...grpc.ServerOption) *Server {
+func NewServer(config Config, opt ...Option) (*Server, error) {
 	s := &Server{}
-	s.Options = &Options{}
-
-	// load certificate from binary
-	s.tls = &tlsConfig{}
-	s.tls.KeyPair, s.tls.CertPool = LoadCert(config.Cert, config.Key)
+	s.config = &config
+	s.Options = &Options{
+		allowOrigin: allowOrigins(config.GrpcWebOrigins),
+	}
+	s.routes = map[string][]string{}
+	s.swagger = swaggerDoc{
+		Paths:       map[string]json.RawMessage{},
+		Definitions: map[string]json.RawMessage{},
+	}
 
 	// set the cofnig for the tcp listener
-	s.Options.GrpcAddr = fmt.Sprintf("%s:%d", config.Hostname, config.Port)
-	logrus.Infof("Server %s", s.Options.GrpcAddr)
+	s.addr = fmt.Sprintf("%s:%d", config.Hostname, config.Port)
+	s.Options.GrpcAddr = s.addr
+	if config.GrpcPort != 0 {
+		s.Options.GrpcAddr = fmt.Sprintf("%s:%d", config.Hostname, config.GrpcPort)
+	}
+	logrus.Infof("Server %s", s.addr)
 
-	// set TLS config for the GRPC gateway clients
-	dcreds := credentials.NewTLS(&tls.Config{
-		ServerName: s.Options.GrpcAddr,
-		RootCAs:    s.tls.CertPool,
-	})
-	s.Options.Dopts = []grpc.DialOption{grpc.WithTransportCredentials(dcreds)}
-
-	// TODO: merge options with provided ones
-	opts := []grpc.ServerOption{
-		grpc.Creds(credentials.NewClientTLSFromCert(
-			s.tls.CertPool,
-			s.Options.GrpcAddr,
-		)),
-		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-			grpc_prometheus.StreamServerInterceptor,
-		)),
-		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-			grpc_prometheus.UnaryServerInterceptor,
-		)),
+	sopts := &serverOptions{
+		maxRecvMsgSize: config.MaxRecvMsgSize,
+		maxSendMsgSize: config.MaxSendMsgSize,
+	}
+	if config.Plaintext {
+		if config.ClientAuth != tls.NoClientCert {
+			return nil, errors.New("NewServer: client authentication requires TLS")
+		}
+
+		// GRPC gateway clients connect via h2c as well
+		s.Options.Dopts = []grpc.DialOption{grpc.WithInsecure()}
+	} else {
+		// load certificate from binary
+		provider := config.CertProvider
+		if provider == nil {
+			var err error
+			provider, err = NewCertProvider(config.Cert, config.Key, config.CA)
+			if err != nil {
+				return nil, errors.Wrap(err, "NewServer")
+			}
+		}
+		s.tls = &tlsConfig{
+			Provider:   provider,
+			ClientAuth: config.ClientAuth,
+		}
+
+		// set TLS config for the GRPC gateway clients, it picks up rotated
+		// certificates on every handshake
+		dcreds := s.tls.Provider.clientCreds(s.addr, s.tls.ClientAuth != tls.NoClientCert)
+		s.Options.Dopts = []grpc.DialOption{grpc.WithTransportCredentials(dcreds)}
+		sopts.creds = credentials.NewTLS(s.tls.Provider.serverTLSConfig(s.tls.ClientAuth))
+	}
+
+	if config.GrpcProxy {
+		sopts.unknownService = s.proxyStream
+	}
+	if config.Reflection && len(config.ReflectionServices) > 0 {
+		sopts.streamInterceptors = append(sopts.streamInterceptors, reflectionInterceptor(config.ReflectionServices))
+	}
+
+	// merge provided options with the defaults
+	for _, o := range opt {
+		o(sopts)
+	}
+	opts := sopts.grpcServerOptions()
+
+	// the gateway sends requests and receives responses of the same size
+	// as the GRPC server accepts them
+	s.copts = sizeCallOptions(sopts.maxRecvMsgSize, sopts.maxSendMsgSize)
+	if len(s.copts) > 0 {
+		s.Options.Dopts = append(s.Options.Dopts, grpc.WithDefaultCallOptions(s.copts...))
+	}
+	// the policies are validated before the backends are dialed, which
+	// would otherwise leak their connection
+	popts, err := CallPolicyDialOptions(config.CallPolicies)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewServer")
+	}
+	if config.Backends != nil {
+		// the backends bring their own service config with the call policies
+		backend, err := config.Backends.Dial(config.CallPolicies, s.Options.Dopts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewServer")
+		}
+		s.Options.Backend = backend
 	}
+	s.Options.Dopts = append(s.Options.Dopts, popts...)
 
 	// initialize GRPC server
 	s.GRPC = grpc.NewServer(opts...)
+	s.Health = health.NewServer()
+	healthpb.RegisterHealthServer(s.GRPC, s.Health)
+	if config.Reflection {
+		registerReflection(s.GRPC, config.ReflectionServices)
+	}
+	if config.GrpcWeb {
+		s.grpcWeb = grpcweb.WrapServer(s.GRPC, grpcweb.WithOriginFunc(s.Options.allowOrigin))
+	}
+
+	// connect the in-process client, the connection is established lazily
+	// once Serve runs
+	s.bufLis = bufconn.Listen(bufconnSize)
+	dopts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+			return s.bufLis.Dial()
+		}),
+	}
+	if len(s.copts) > 0 {
+		dopts = append(dopts, grpc.WithDefaultCallOptions(s.copts...))
+	}
+	dopts = append(dopts, popts...)
+	conn, err := grpc.Dial("bufconn", dopts...)
+	if err != nil {
+		if s.Options.Backend != nil {
+			s.Options.Backend.Close()
+		}
+		return nil, errors.Wrap(err, "NewServer")
+	}
+	s.Options.Conn = conn
+
 	// initialize REST 1.1 handler
 	s.Mux = http.NewServeMux()
-	return s
+	s.Admin = http.NewServeMux()
+	registerHealth(s.Admin, s.Health)
+	registerRoutes(s.Admin, s)
+
+	// the merged swagger document is served next to the REST routes
+	s.routes[SwaggerPath] = nil
+	registerSwagger(s.Mux, s)
+	return s, nil
+}
+
+// sizeCallOptions mirrors the server message size limits for its clients
+func sizeCallOptions(maxRecvMsgSize, maxSendMsgSize int) []grpc.CallOption {
+	var copts []grpc.CallOption
+	if maxRecvMsgSize > 0 {
+		copts = append(copts, grpc.MaxCallSendMsgSize(maxRecvMsgSize))
+	}
+	if maxSendMsgSize > 0 {
+		copts = append(copts, grpc.MaxCallRecvMsgSize(maxSendMsgSize))
+	}
+	return copts
 }
 
 // handle GRPC returns
 func (s *Server) grpcHandlerFunc(grpcServer *grpc.Server, muxHandler http.Handler) http.Handler {
+	muxHandler = s.grpcWebHandler(muxHandler)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// partial check of https://github.com/grpc/grpc-go/blob/master/transport/handler_server.go#L50
-		if r.ProtoMajor >= 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc") {
+		contentType := r.Header.Get("Content-Type")
+		if r.ProtoMajor >= 2 && strings.HasPrefix(contentType, "application/grpc") && !strings.HasPrefix(contentType, "application/grpc-web") {
 			grpcServer.ServeHTTP(w, r)
 		} else {
 			muxHandler.ServeHTTP(w, r)
@@ -110,54 +315,422 @@ func (s *Server) grpcHandlerFunc(grpcServer *grpc.Server, muxHandler http.Handle
 	})
 }
 
+// grpcWebHandler serves grpc-web requests and their CORS preflight requests,
+// everything else is passed to the handler
+func (s *Server) grpcWebHandler(handler http.Handler) http.Handler {
+	if s.grpcWeb == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.grpcWeb.IsGrpcWebRequest(r) || s.grpcWeb.IsAcceptableGrpcCorsRequest(r) {
+			s.grpcWeb.ServeHTTP(w, r)
+		} else {
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
+
+// allowOrigins returns the origin check for grpc-web and websockets
+func allowOrigins(origins []string) func(string) bool {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+	return func(origin string) bool {
+		return allowed["*"] || allowed[origin]
+	}
+}
+
 func (s *Server) Serve() error {
+	// refuse to serve an incomplete set of routes
+	if err := s.registrationErr(); err != nil {
+		return errors.Wrap(err, "Serve")
+	}
+
 	logrus.Info("Start server")
 
 	// After all your registrations, make sure all of the Prometheus metrics are initialized.
 	grpc_prometheus.Register(s.GRPC)
 
 	// Register Prometheus metrics handler.
-	s.Mux.Handle("/metrics", promhttp.Handler())
+	s.Admin.Handle("/metrics", promhttp.Handler())
+
+	// assemble the listeners, services without a dedicated port are served
+	// on the shared one
+	var shared []*http.ServeMux
+	listeners := []*listener{{
+		addr:  "bufconn",
+		lis:   s.bufLis,
+		serve: s.serveGRPC,
+	}}
+	if s.config.GrpcPort != 0 {
+		listeners = append(listeners, &listener{
+			addr:  s.Options.GrpcAddr,
+			serve: s.serveGRPC,
+		})
+	}
+	if s.config.AdminPort == 0 {
+		shared = append(shared, s.Admin)
+	}
+	if s.config.RestPort != 0 {
+		l, err := s.httpListener(fmt.Sprintf("%s:%d", s.config.Hostname, s.config.RestPort), s.grpcWebHandler(s.Mux))
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	} else {
+		shared = append(shared, s.Mux)
+	}
+	if s.config.GrpcPort == 0 || len(shared) > 0 {
+		var handler http.Handler = mergeMux(shared...)
+		if s.config.GrpcPort == 0 {
+			handler = s.grpcHandlerFunc(s.GRPC, handler)
+		} else if s.config.RestPort == 0 {
+			handler = s.grpcWebHandler(handler)
+		}
+		l, err := s.httpListener(s.addr, handler)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	// the admin server is created last, so that it is shut down last and
+	// reports the readiness while the other listeners drain
+	if s.config.AdminPort != 0 {
+		registerPprof(s.Admin)
+		l, err := s.httpListener(fmt.Sprintf("%s:%d", s.config.Hostname, s.config.AdminPort), s.Admin)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	// bind all ports before serving, so that a port conflict fails early
+	for i, l := range listeners {
+		if l.lis != nil {
+			continue
+		}
+		lis, err := net.Listen("tcp", l.addr)
+		if err != nil {
+			for _, bound := range listeners[:i] {
+				bound.lis.Close()
+			}
+			s.closeConns()
+			return errors.Wrapf(err, "listen on %s", l.addr)
+		}
+		l.lis = lis
+	}
+
+	// Shutdown only stops the servers it knows about, therefore they are
+	// registered together with checking whether Shutdown already started
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		for _, l := range listeners {
+			l.lis.Close()
+		}
+		return nil
+	}
+	for _, l := range listeners {
+		if l.srv != nil {
+			s.servers = append(s.servers, l.srv)
+		}
+	}
+	s.mu.Unlock()
 
-	// start server
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		logrus.Infof("listen on %s", l.addr)
+		go func(l *listener) {
+			errc <- l.serve(l.lis)
+		}(l)
+	}
+
+	// if one listener fails, stop the others as well
+	var err error
+	for range listeners {
+		if serr := <-errc; serr != nil && err == nil {
+			err = errors.Wrap(serr, "Serve")
+			s.close()
+		}
+	}
+	return err
+}
+
+// listener serves one of the configured ports
+type listener struct {
+	addr  string
+	lis   net.Listener
+	serve func(net.Listener) error
+	// srv is the http server of the port, it is nil for GRPC ports
+	srv *http.Server
+}
+
+// serveGRPC serves GRPC on the listener until the server is stopped
+func (s *Server) serveGRPC(lis net.Listener) error {
+	err := s.GRPC.Serve(lis)
+	if err == grpc.ErrServerStopped {
+		// Shutdown stopped the server before it started serving
+		return nil
+	}
+	return err
+}
+
+// httpListener creates a listener for the http handler. It serves TLS or h2c
+// depending on the config.
+func (s *Server) httpListener(addr string, handler http.Handler) (*listener, error) {
 	srv := &http.Server{
-		Addr:    s.Options.GrpcAddr,
-		Handler: s.grpcHandlerFunc(s.GRPC, s.Mux),
-		TLSConfig: &tls.Config{
-			Certificates: []tls.Certificate{*s.tls.KeyPair},
-			NextProtos:   []string{"h2"},
+		Addr:    addr,
+		Handler: s.trackInflight(handler),
+	}
+
+	if s.config.Plaintext {
+		// h2c connections are hijacked from the http server, ConfigureServer
+		// ensures they receive a GOAWAY on Shutdown
+		h2s := &http2.Server{}
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			return nil, errors.Wrap(err, "configure h2c")
+		}
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	} else {
+		srv.TLSConfig = s.tls.Provider.serverTLSConfig(s.tls.ClientAuth)
+	}
+
+	return &listener{
+		addr: addr,
+		srv:  srv,
+		serve: func(lis net.Listener) error {
+			if !s.config.Plaintext {
+				lis = tls.NewListener(lis, srv.TLSConfig)
+			}
+			err := srv.Serve(lis)
+			if err == http.ErrServerClosed {
+				// regular termination via Shutdown
+				return nil
+			}
+			return err
 		},
+	}, nil
+}
+
+// mergeMux serves a request with the first mux that has a matching route
+func mergeMux(muxes ...*http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, mux := range muxes {
+			if _, pattern := mux.Handler(r); pattern != "" {
+				mux.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// Shutdown gracefully stops the server. It stops accepting new connections,
+// drains in-flight REST and GRPC requests and returns once both are done. If
+// the context expires first, all remaining connections are closed forcefully.
+func (s *Server) Shutdown(ctx context.Context) error {
+	logrus.Info("Shutdown server")
+
+	// report all services as NOT_SERVING while draining
+	s.Health.Shutdown()
+
+	s.mu.Lock()
+	s.shutdown = true
+	servers := s.servers
+	s.mu.Unlock()
+
+	// GRPC requests may be served through the http server, therefore the http
+	// servers need to drain first. Stopping GRPC before would cancel the
+	// streams that are still in-flight.
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			s.close()
+			return errors.Wrap(err, "Shutdown")
+		}
 	}
+	// h2c connections are not tracked by the http server
+	if err := s.waitInflight(ctx); err != nil {
+		s.close()
+		return errors.Wrap(err, "Shutdown")
+	}
+	// all REST requests are done, nobody uses the in-process connection anymore
+	s.closeConns()
 
-	lis, err := net.Listen("tcp", s.Options.GrpcAddr)
-	if err != nil {
-		return errors.Wrapf(err, "listen on %s", s.Options.GrpcAddr)
+	stopped := make(chan struct{})
+	go func() {
+		s.GRPC.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.GRPC.Stop()
+		return errors.Wrap(ctx.Err(), "Shutdown")
+	}
+}
+
+// close stops all listeners immediately
+func (s *Server) close() {
+	s.mu.Lock()
+	servers := s.servers
+	s.mu.Unlock()
+
+	for _, srv := range servers {
+		srv.Close()
+	}
+	s.closeConns()
+	s.GRPC.Stop()
+}
+
+// closeConns closes the client connections of the REST gateway
+func (s *Server) closeConns() {
+	s.Options.Conn.Close()
+	if s.Options.Backend != nil {
+		s.Options.Backend.Close()
+	}
+	s.mu.Lock()
+	for _, r := range s.proxies {
+		r.conn.Close()
 	}
+	s.mu.Unlock()
+}
 
-	tlslis := tls.NewListener(lis, srv.TLSConfig)
-	err = srv.Serve(tlslis)
-	return errors.Wrap(err, "Serve")
+// trackInflight counts the requests that are currently handled
+func (s *Server) trackInflight(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.inflight++
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			s.inflight--
+			s.mu.Unlock()
+		}()
+		h.ServeHTTP(w, r)
+	})
 }
 
-// Handle adds a http.ServerMux route to the server. It will serve http 1.1 requests only
-func (s *Server) Handle(pattern string, handler func(*Options) (mux *http.ServeMux, err error)) {
+// waitInflight blocks until all requests are handled or the context is done
+func (s *Server) waitInflight(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := s.inflight
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Handle adds a http.ServerMux route to the server. It will serve http 1.1
+// requests only. The pattern needs to start with / and may only be registered
+// once. Without dedicated ports, the admin routes like /metrics cannot be
+// registered. Serve refuses to start if a registration failed.
+func (s *Server) Handle(pattern string, handler func(*Options) (mux *http.ServeMux, err error)) error {
+	return s.HandleGateway(pattern, handler)
+}
+
+// HandleGateway adds a route like Handle. In addition, the route is known to
+// serve the GRPC gateway bindings of the given GRPC services, which are then
+// listed by Routes.
+func (s *Server) HandleGateway(pattern string, handler func(*Options) (mux *http.ServeMux, err error), services ...string) error {
+	err := s.handle(pattern, handler, services)
+	if err != nil {
+		s.registrationFailed(err)
+	}
+	return err
+}
+
+func (s *Server) handle(pattern string, handler func(*Options) (mux *http.ServeMux, err error), services []string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return errors.Errorf("register route %q: pattern needs to start with /", pattern)
+	}
+	if s.config.AdminPort == 0 && s.config.RestPort == 0 {
+		for _, route := range adminRoutes {
+			if strings.TrimSuffix(pattern, "/") == route {
+				return errors.Errorf("register route %s: the admin route shares the port, set Config.AdminPort", pattern)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	if _, ok := s.routes[pattern]; ok {
+		s.mu.Unlock()
+		return errors.Errorf("register route %s: route is already registered", pattern)
+	}
+	s.routes[pattern] = services
+	s.mu.Unlock()
+
 	mux, err := handler(s.Options)
+	if err == nil && mux == nil {
+		err = errors.New("handler returned no mux")
+	}
 	if err != nil {
-		logrus.Error(err)
+		// release the pattern, only served routes are listed
+		s.mu.Lock()
+		delete(s.routes, pattern)
+		s.mu.Unlock()
+		return errors.Wrapf(err, "register route %s", pattern)
 	}
 
 	// strip ending / from subroutes
-	l := len(pattern)
-	subPattern := pattern
-	if string(pattern[l-1]) == "/" {
-		subPattern = pattern[0 : l-1]
-	}
+	subPattern := strings.TrimSuffix(pattern, "/")
 
 	logrus.Infof("register route %s", pattern)
-	s.Mux.Handle(pattern, http.StripPrefix(subPattern, mux))
+	s.Mux.Handle(pattern, peerIdentityHandler(http.StripPrefix(subPattern, mux)))
+	return nil
+}
+
+// HandleGRPC registers a GRPC endpoint to the server. All services it
+// registers are reported as SERVING by the health service. Serve refuses to
+// start if a registration failed.
+func (s *Server) HandleGRPC(handler func(*Options, *grpc.Server) (err error)) error {
+	registered := s.GRPC.GetServiceInfo()
+	if err := handler(s.Options, s.GRPC); err != nil {
+		err = errors.Wrap(err, "register GRPC services")
+		s.registrationFailed(err)
+		return err
+	}
+
+	for name := range s.GRPC.GetServiceInfo() {
+		if _, ok := registered[name]; !ok {
+			s.Health.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+	return nil
 }
 
-// HandleGRPC registers a GRPC endpoint to the server
-func (s *Server) HandleGRPC(handler func(*Options, *grpc.Server) (err error)) {
-	handler(s.Options, s.GRPC)
+// registrationFailed remembers the error, so that Serve refuses to start
+func (s *Server) registrationFailed(err error) {
+	logrus.Error(err)
+
+	s.mu.Lock()
+	s.registrationErrs = append(s.registrationErrs, err)
+	s.mu.Unlock()
+}
+
+// registrationErr summarizes all failed registrations
+func (s *Server) registrationErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.registrationErrs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(s.registrationErrs))
+	for i, err := range s.registrationErrs {
+		msgs[i] = err.Error()
+	}
+	return errors.Errorf("%d registrations failed: %s", len(msgs), strings.Join(msgs, "; "))
 }
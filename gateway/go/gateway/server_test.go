@@ -0,0 +1,539 @@
+package gateway
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startPingPong runs a plaintext gateway with the pingpong service on a
+// random port. Use Options.Conn to talk to it.
+func startPingPong(t *testing.T, config Config, opt ...Option) *Server {
+	config.Hostname = "localhost"
+	config.Plaintext = true
+
+	s, err := NewServer(config, opt...)
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	err = s.HandleGRPC(func(opts *Options, grpcServer *grpc.Server) error {
+		pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not register grpc service %v", err)
+	}
+	go s.Serve()
+	return s
+}
+
+// freePort returns a port that is free right now
+func freePort(t *testing.T) int {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().(*net.TCPAddr).Port
+}
+
+// waitListening waits until Serve accepts connections on addr
+func waitListening(t *testing.T, addr string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s is not listening", addr)
+}
+
+func newRoute(opts *Options) (*http.ServeMux, error) {
+	return http.NewServeMux(), nil
+}
+
+func TestHandleRejectsInvalidRoutes(t *testing.T) {
+	s, err := NewServer(Config{Hostname: "localhost", Plaintext: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+
+	if err := s.Handle("/pingpong/", newRoute); err != nil {
+		t.Fatalf("could not register route %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		handler func(*Options) (*http.ServeMux, error)
+	}{
+		{"empty pattern", "", newRoute},
+		{"relative pattern", "pingpong/", newRoute},
+		{"duplicate pattern", "/pingpong/", newRoute},
+		{"handler error", "/broken/", func(*Options) (*http.ServeMux, error) {
+			return nil, errors.New("broken")
+		}},
+		{"missing mux", "/nil/", func(*Options) (*http.ServeMux, error) {
+			return nil, nil
+		}},
+	}
+	for _, tt := range tests {
+		if err := s.Handle(tt.pattern, tt.handler); err == nil {
+			t.Errorf("%s: expected error for %q", tt.name, tt.pattern)
+		}
+	}
+
+	err = s.Serve()
+	if err == nil || !strings.Contains(err.Error(), "5 registrations failed") {
+		t.Fatalf("expected Serve to refuse to start, got %v", err)
+	}
+}
+
+func TestHandleRejectsAdminRoutes(t *testing.T) {
+	s, err := NewServer(Config{Hostname: "localhost", Plaintext: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	for _, pattern := range []string{"/metrics", "/healthz/", "/readyz", "/routes"} {
+		if err := s.Handle(pattern, newRoute); err == nil {
+			t.Errorf("expected an error for %s on the shared port", pattern)
+		}
+	}
+
+	// the admin routes are on their own port
+	s, err = NewServer(Config{Hostname: "localhost", Plaintext: true, AdminPort: freePort(t)})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	defer s.Shutdown(context.Background())
+	if err := s.Handle("/metrics", newRoute); err != nil {
+		t.Errorf("could not register /metrics with an admin port %v", err)
+	}
+}
+
+func TestHandleGRPCError(t *testing.T) {
+	s, err := NewServer(Config{Hostname: "localhost", Plaintext: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+
+	err = s.HandleGRPC(func(*Options, *grpc.Server) error {
+		return errors.New("broken")
+	})
+	if err == nil {
+		t.Fatal("expected error from HandleGRPC")
+	}
+	if err := s.Serve(); err == nil {
+		t.Fatal("expected Serve to refuse to start")
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	s := startPingPong(t, Config{})
+	defer s.Shutdown(context.Background())
+
+	if err := s.HandleGateway("/pingpong/", newRoute, "pingpong.PingPong"); err != nil {
+		t.Fatalf("could not register route %v", err)
+	}
+	if err := s.Handle("/broken/", func(*Options) (*http.ServeMux, error) {
+		return nil, errors.New("broken")
+	}); err == nil {
+		t.Fatal("expected error for /broken/")
+	}
+
+	routes := s.Routes()
+	if strings.Join(routes.REST, ",") != "/pingpong/,"+SwaggerPath {
+		t.Errorf("expected /pingpong/ and %s, got %v", SwaggerPath, routes.REST)
+	}
+
+	var methods []string
+	for _, svc := range routes.GRPC {
+		if svc.Name == "pingpong.PingPong" {
+			for _, m := range svc.Methods {
+				methods = append(methods, fmt.Sprintf("%s:%t:%t", m.Name, m.ClientStreaming, m.ServerStreaming))
+			}
+		}
+	}
+	expectedMethods := "NoPing:false:false,Ping:false:false,PingCollect:true:false,PingPayload:false:false,PingPongStream:true:true,PingStream:false:true"
+	if strings.Join(methods, ",") != expectedMethods {
+		t.Errorf("expected methods %s, got %v", expectedMethods, methods)
+	}
+
+	expected := []HTTPBinding{
+		{Method: "POST", Path: "/pingpong/ping", RPC: "pingpong.PingPong.Ping", Body: "*"},
+		{Method: "GET", Path: "/pingpong/pong", RPC: "pingpong.PingPong.NoPing"},
+		{Method: "POST", Path: "/pingpong/payload", RPC: "pingpong.PingPong.PingPayload", Body: "*"},
+	}
+	if len(routes.Bindings) != len(expected) {
+		t.Fatalf("expected bindings %v, got %v", expected, routes.Bindings)
+	}
+	for i, b := range expected {
+		if routes.Bindings[i] != b {
+			t.Errorf("expected binding %v, got %v", b, routes.Bindings[i])
+		}
+	}
+}
+
+func TestRoutesHidesServices(t *testing.T) {
+	s := startPingPong(t, Config{
+		Reflection:         true,
+		ReflectionServices: []string{"grpc.health.v1.Health"},
+	})
+	defer s.Shutdown(context.Background())
+	if err := s.HandleGateway("/pingpong/", newRoute, "pingpong.PingPong"); err != nil {
+		t.Fatalf("could not register route %v", err)
+	}
+
+	routes := s.Routes()
+	var names []string
+	for _, svc := range routes.GRPC {
+		names = append(names, svc.Name)
+	}
+	if strings.Join(names, ",") != "grpc.health.v1.Health" {
+		t.Errorf("expected only the health service, got %v", names)
+	}
+	if len(routes.Bindings) != 0 {
+		t.Errorf("expected no bindings of hidden services, got %v", routes.Bindings)
+	}
+}
+
+func TestMaxMsgSize(t *testing.T) {
+	for name, start := range map[string]func() *Server{
+		"config": func() *Server {
+			return startPingPong(t, Config{
+				MaxRecvMsgSize: 64 * 1024,
+				MaxSendMsgSize: 64 * 1024,
+			})
+		},
+		"options": func() *Server {
+			return startPingPong(t, Config{}, WithMaxRecvMsgSize(64*1024), WithMaxSendMsgSize(64*1024))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := start()
+			defer s.Shutdown(context.Background())
+
+			client := pingpong.NewPingPongClient(s.Options.Conn)
+			res, err := client.PingPayload(context.Background(), &pingpong.Payload{Data: make([]byte, 32*1024)}, grpc.FailFast(false))
+			if err != nil {
+				t.Fatalf("could not send payload %v", err)
+			}
+			if len(res.GetData()) != 32*1024 {
+				t.Errorf("expected 32KB echo, got %d bytes", len(res.GetData()))
+			}
+
+			// the client refuses to send what the server would not accept
+			_, err = client.PingPayload(context.Background(), &pingpong.Payload{Data: make([]byte, 128*1024)}, grpc.FailFast(false))
+			if st, _ := status.FromError(err); st.Code() != codes.ResourceExhausted || !strings.Contains(st.Message(), "trying to send message larger than max") {
+				t.Fatalf("expected ResourceExhausted from the client, got %v", err)
+			}
+		})
+	}
+}
+
+func TestShutdownDrainsRequests(t *testing.T) {
+	port := freePort(t)
+	s, err := NewServer(Config{Hostname: "localhost", Port: port, Plaintext: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	err = s.Handle("/slow/", func(*Options) (*http.ServeMux, error) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			fmt.Fprint(w, "done")
+		})
+		return mux, nil
+	})
+	if err != nil {
+		t.Fatalf("could not register route %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+	addr := fmt.Sprintf("localhost:%d", port)
+	waitListening(t, addr)
+
+	res := make(chan error, 1)
+	go func() {
+		r, err := http.Get("http://" + addr + "/slow/")
+		if err == nil {
+			r.Body.Close()
+			if r.StatusCode != http.StatusOK {
+				err = errors.Errorf("unexpected status %s", r.Status)
+			}
+		}
+		res <- err
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// new connections are refused while the request is in-flight
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("expected new connections to be refused")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected Shutdown to wait for the request, got %v", err)
+	default:
+	}
+
+	close(release)
+	if err := <-res; err != nil {
+		t.Errorf("expected the in-flight request to finish %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("could not shut down %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected Serve to return after Shutdown, got %v", err)
+	}
+}
+
+func TestShutdownBeforeServe(t *testing.T) {
+	port := freePort(t)
+	s, err := NewServer(Config{Hostname: "localhost", Port: port, AdminPort: freePort(t), Plaintext: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("could not shut down %v", err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- s.Serve() }()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected Serve to return after Shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Serve to return after Shutdown")
+	}
+	if conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port)); err == nil {
+		conn.Close()
+		t.Error("expected no listener after Shutdown")
+	}
+}
+
+func TestLoadCert(t *testing.T) {
+	ca, other := newTestCA(t, "gyrpsy CA"), newTestCA(t, "other CA")
+	cert, key := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}, DNSNames: []string{"localhost"}})
+	otherCert, otherKey := other.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "localhost"}, DNSNames: []string{"localhost"}})
+	bundle := append(append([]byte{}, ca.pem...), other.pem...)
+
+	tests := []struct {
+		name string
+		key  []byte
+		ca   [][]byte
+		err  string
+		// trusted are verified by the pool
+		trusted [][]byte
+	}{
+		{name: "without ca", key: key, trusted: [][]byte{cert}},
+		{name: "ca", key: key, ca: [][]byte{ca.pem}, trusted: [][]byte{cert}},
+		{name: "bundle", key: key, ca: [][]byte{bundle}, trusted: [][]byte{cert, otherCert}},
+		{name: "several bundles", key: key, ca: [][]byte{ca.pem, other.pem}, trusted: [][]byte{cert, otherCert}},
+		{name: "mismatched key", key: otherKey, err: "load key pair"},
+		{name: "invalid ca", key: key, ca: [][]byte{[]byte("-----BEGIN CERTIFICATE-----\nbroken\n-----END CERTIFICATE-----\n")}, err: "load CA"},
+		{name: "invalid second ca", key: key, ca: [][]byte{ca.pem, []byte("not a certificate")}, err: "load CA"},
+	}
+	for _, tt := range tests {
+		pair, pool, err := LoadCert(cert, tt.key, tt.ca...)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: could not load %v", tt.name, err)
+			continue
+		}
+		if len(pair.Certificate) != 1 {
+			t.Errorf("%s: expected the certificate in the key pair", tt.name)
+		}
+		for _, c := range tt.trusted {
+			block, _ := pem.Decode(c)
+			leaf, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := leaf.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"}); err != nil {
+				t.Errorf("%s: expected %s to be trusted %v", tt.name, leaf.Issuer.CommonName, err)
+			}
+		}
+	}
+}
+
+// get returns the status code of a GET request, or 0 if it fails. The body
+// is read, so that the connection is reused. An unused connection would
+// delay Shutdown.
+func get(url string) int {
+	res, err := http.Get(url)
+	if err != nil {
+		return 0
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestSeparatePorts(t *testing.T) {
+	grpcPort, restPort, adminPort := freePort(t), freePort(t), freePort(t)
+	s, err := NewServer(Config{
+		Hostname:  "localhost",
+		Port:      freePort(t),
+		GrpcPort:  grpcPort,
+		RestPort:  restPort,
+		AdminPort: adminPort,
+		Plaintext: true,
+	})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	err = s.HandleGRPC(func(opts *Options, grpcServer *grpc.Server) error {
+		pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not register grpc service %v", err)
+	}
+	err = s.Handle("/rest/", func(*Options) (*http.ServeMux, error) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(http.ResponseWriter, *http.Request) {})
+		return mux, nil
+	})
+	if err != nil {
+		t.Fatalf("could not register route %v", err)
+	}
+	go s.Serve()
+	defer s.Shutdown(context.Background())
+
+	grpcAddr := fmt.Sprintf("localhost:%d", grpcPort)
+	restAddr := fmt.Sprintf("localhost:%d", restPort)
+	adminAddr := fmt.Sprintf("localhost:%d", adminPort)
+	for _, addr := range []string{grpcAddr, restAddr, adminAddr} {
+		waitListening(t, addr)
+	}
+
+	// GRPC is only served on its port
+	for addr, ok := range map[string]bool{grpcAddr: true, restAddr: false, adminAddr: false} {
+		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = pingpong.NewPingPongClient(conn).Ping(context.Background(), &pingpong.PingRequest{Sender: "John"})
+		conn.Close()
+		if ok != (err == nil) {
+			t.Errorf("%s: expected GRPC to be served %t, got %v", addr, ok, err)
+		}
+	}
+
+	// REST routes and the admin routes are only served on their ports
+	tests := []struct {
+		path string
+		addr string
+		code int
+	}{
+		{"/rest/", restAddr, http.StatusOK},
+		{"/rest/", adminAddr, http.StatusNotFound},
+		{"/rest/", grpcAddr, 0},
+		{"/readyz", adminAddr, http.StatusOK},
+		{"/readyz", restAddr, http.StatusNotFound},
+		{"/metrics", adminAddr, http.StatusOK},
+		{"/metrics", restAddr, http.StatusNotFound},
+		{"/debug/pprof/", adminAddr, http.StatusOK},
+		{"/debug/pprof/", restAddr, http.StatusNotFound},
+		{"/debug/pprof/", grpcAddr, 0},
+		{"/routes", adminAddr, http.StatusOK},
+		{"/routes", restAddr, http.StatusNotFound},
+		{"/routes", grpcAddr, 0},
+	}
+	for _, tt := range tests {
+		if code := get("http://" + tt.addr + tt.path); code != tt.code {
+			t.Errorf("%s%s: expected %d, got %d", tt.addr, tt.path, tt.code, code)
+		}
+	}
+}
+
+func TestSharedPortWithoutPprof(t *testing.T) {
+	port := freePort(t)
+	s := startPingPong(t, Config{Port: port})
+	defer s.Shutdown(context.Background())
+	addr := fmt.Sprintf("localhost:%d", port)
+	waitListening(t, addr)
+
+	// the profiles are only exposed on a dedicated admin port
+	if code := get("http://" + addr + "/debug/pprof/"); code != http.StatusNotFound {
+		t.Errorf("expected no pprof on the shared port, got %d", code)
+	}
+	if code := get("http://" + addr + "/readyz"); code != http.StatusOK {
+		t.Errorf("expected /readyz on the shared port, got %d", code)
+	}
+}
+
+func TestServeClosesListenersOnBindFailure(t *testing.T) {
+	ports := []int{freePort(t), freePort(t), freePort(t)}
+	// the admin port is bound last
+	busy, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	s, err := NewServer(Config{
+		Hostname:  "localhost",
+		Port:      ports[0],
+		GrpcPort:  ports[1],
+		RestPort:  ports[2],
+		AdminPort: busy.Addr().(*net.TCPAddr).Port,
+		Plaintext: true,
+	})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+	if err := s.Serve(); err == nil || !strings.Contains(err.Error(), "listen on") {
+		t.Fatalf("expected Serve to fail to listen, got %v", err)
+	}
+
+	for _, port := range ports {
+		lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+		if err != nil {
+			t.Errorf("expected port %d to be closed %v", port, err)
+			continue
+		}
+		lis.Close()
+	}
+}
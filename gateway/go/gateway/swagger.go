@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SwaggerPath is the well-known route of the merged swagger document
+const SwaggerPath = "/swagger.json"
+
+// swaggerDoc is the part of a swagger document that is merged, everything
+// else is taken from the defaults
+type swaggerDoc struct {
+	Paths       map[string]json.RawMessage `json:"paths"`
+	Definitions map[string]json.RawMessage `json:"definitions"`
+}
+
+// AddSwagger adds the swagger document of a route, like the protoc-gen-swagger
+// output of the services served by HandleGateway. All paths are mounted below
+// the pattern. The merged document of all routes is served at SwaggerPath.
+// Serve refuses to start if a document could not be added.
+func (s *Server) AddSwagger(pattern string, spec []byte) error {
+	err := s.addSwagger(pattern, spec)
+	if err != nil {
+		s.registrationFailed(err)
+	}
+	return err
+}
+
+func (s *Server) addSwagger(pattern string, spec []byte) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return errors.Errorf("add swagger %q: pattern needs to start with /", pattern)
+	}
+
+	doc := swaggerDoc{}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return errors.Wrapf(err, "add swagger %s", pattern)
+	}
+
+	// strip ending / from subroutes
+	prefix := strings.TrimSuffix(pattern, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// check for conflicts before anything is merged
+	for path := range doc.Paths {
+		if _, ok := s.swagger.Paths[prefix+path]; ok {
+			return errors.Errorf("add swagger %s: path %s is already documented", pattern, prefix+path)
+		}
+	}
+	for name, def := range doc.Definitions {
+		if existing, ok := s.swagger.Definitions[name]; ok && !jsonEqual(existing, def) {
+			return errors.Errorf("add swagger %s: definition %s differs from an existing one", pattern, name)
+		}
+	}
+
+	for path, item := range doc.Paths {
+		s.swagger.Paths[prefix+path] = item
+	}
+	for name, def := range doc.Definitions {
+		s.swagger.Definitions[name] = def
+	}
+	return nil
+}
+
+// jsonEqual compares two JSON documents, ignoring whitespace
+func jsonEqual(a, b json.RawMessage) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return false
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// registerSwagger serves the merged swagger document
+func registerSwagger(mux *http.ServeMux, s *Server) {
+	mux.HandleFunc(SwaggerPath, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		doc := map[string]interface{}{
+			"swagger": "2.0",
+			"info": map[string]string{
+				"title":   s.addr,
+				"version": "version not set",
+			},
+			"schemes":     []string{s.scheme()},
+			"consumes":    []string{"application/json"},
+			"produces":    []string{"application/json"},
+			"paths":       s.swagger.Paths,
+			"definitions": s.swagger.Definitions,
+		}
+		b, err := json.MarshalIndent(doc, "", "  ")
+		s.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
+
+// scheme returns the scheme the REST routes are served with
+func (s *Server) scheme() string {
+	if s.config.Plaintext {
+		return "http"
+	}
+	return "https"
+}
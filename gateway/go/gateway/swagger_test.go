@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chris-rock/gyrpsy/api/pingpong"
+)
+
+func TestSwaggerMerge(t *testing.T) {
+	s, err := NewServer(Config{Hostname: "localhost", Plaintext: true})
+	if err != nil {
+		t.Fatalf("could not create server %v", err)
+	}
+
+	for _, pattern := range []string{"/pingpong/", "/v2/pingpong/"} {
+		if err := s.AddSwagger(pattern, []byte(pingpong.Swagger)); err != nil {
+			t.Fatalf("could not add swagger for %s: %v", pattern, err)
+		}
+	}
+	if err := s.AddSwagger("/pingpong/", []byte(pingpong.Swagger)); err == nil {
+		t.Error("expected error for duplicate paths")
+	}
+
+	w := httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, SwaggerPath, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	doc := struct {
+		Swagger     string                     `json:"swagger"`
+		Paths       map[string]json.RawMessage `json:"paths"`
+		Definitions map[string]json.RawMessage `json:"definitions"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("could not parse swagger document %v", err)
+	}
+	if doc.Swagger != "2.0" {
+		t.Errorf("expected swagger 2.0, got %q", doc.Swagger)
+	}
+	for _, path := range []string{"/pingpong/ping", "/pingpong/pong", "/pingpong/payload", "/v2/pingpong/ping", "/v2/pingpong/pong", "/v2/pingpong/payload"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Errorf("expected path %s, got %v", path, doc.Paths)
+		}
+	}
+	if len(doc.Paths) != 6 {
+		t.Errorf("expected 6 paths, got %d", len(doc.Paths))
+	}
+	if _, ok := doc.Definitions["pingpongPingRequest"]; !ok {
+		t.Errorf("expected definition pingpongPingRequest, got %v", doc.Definitions)
+	}
+}
@@ -1,46 +1,95 @@
 package main
 
 import (
+	"flag"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/chris-rock/gyrpsy/api/pingpong"
 	server "github.com/chris-rock/gyrpsy/gateway/go/gateway"
+	"github.com/golang/protobuf/proto"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var port = 5000
+var plaintextPort = 5004
+var shutdownTimeout = 30 * time.Second
+var certWatchInterval = 10 * time.Second
+var callTimeout = 5 * time.Second
 
-func GetCertificates(keyFilename string, certFilename string) (ko []byte, co []byte) {
-	// load key from disk
-	var err error
-	ko, err = ioutil.ReadFile(keyFilename)
-	if err != nil {
-		log.Fatalf("failed to open %s for reading: %s", keyFilename, err)
-		panic("could not load tls key")
-	}
-	co, err = ioutil.ReadFile(certFilename)
-	if err != nil {
-		log.Fatalf("failed to open %s for reading: %s", certFilename, err)
-		panic("could not load tls cert")
+var plaintext = flag.Bool("plaintext", false, "serve GRPC and REST via h2c without TLS")
+var backends = flag.String("backends", "", "comma separated pp_grpc addresses or a resolver target like dns:///pp-grpc:5001, the REST gateway balances across them instead of calling the in-process server")
+var balancer = flag.String("balancer", server.RoundRobin, "balancer of the backends, round_robin or gateway_least_request")
+var grpcProxy = flag.String("grpcproxy", "", "semicolon separated routes of GRPC methods to plaintext upstreams, eg. /echo.Echo/=localhost:7001,localhost:7002;/chat.=dns:///chat:7000")
+
+// reloadOnHangup reloads the certificates on SIGHUP
+func reloadOnHangup(certs *server.CertProvider) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := certs.Reload(); err != nil {
+			logrus.Errorf("failed to reload certificates: %v", err)
+		}
 	}
-	return ko, co
 }
 
 func main() {
-	key, cert := GetCertificates("./cert/key_localhost_5000.pem", "./cert/cert_localhost_5000.pem")
-	s := server.NewServer(server.Config{
-		Hostname: "localhost",
-		Port:     port,
-		Key:      key,
-		Cert:     cert,
-	})
+	flag.Parse()
+
+	config := server.Config{
+		Hostname:           "localhost",
+		Port:               port,
+		Reflection:         true,
+		ReflectionServices: []string{"pingpong.PingPong"},
+		GrpcWeb:            true,
+		GrpcProxy:          *grpcProxy != "",
+		// retry the gateway calls while the backend restarts
+		CallPolicies: []server.CallPolicy{
+			{Service: "pingpong.PingPong", Timeout: callTimeout, Retry: &server.RetryPolicy{}},
+		},
+	}
+	if *plaintext {
+		config.Port = plaintextPort
+		config.Plaintext = true
+	} else {
+		// load certificates from disk and pick up rotated files
+		certs, err := server.NewFileCertProvider("./cert/cert_localhost_5000.pem", "./cert/key_localhost_5000.pem", "")
+		if err != nil {
+			log.Fatalf("failed to load certificates: %v", err)
+		}
+		go certs.Watch(context.Background(), certWatchInterval)
+		go reloadOnHangup(certs)
+		config.CertProvider = certs
+	}
+
+	if *backends != "" {
+		config.Backends = server.ParseBackends(*backends)
+		config.Backends.Balancer = *balancer
+		config.Backends.HealthCheck = true
+		if !*plaintext {
+			// pp_grpc has its own certificate
+			creds, err := credentials.NewClientTLSFromFile("./cert/cert_localhost_5001.pem", "localhost")
+			if err != nil {
+				log.Fatalf("failed to load backend certificate: %v", err)
+			}
+			config.Backends.Dopts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		}
+	}
+
+	s, err := server.NewServer(config)
+	if err != nil {
+		log.Fatalf("failed to create server: %v", err)
+	}
 
 	// handle all GRPC services
 	grpcHandler := func(opts *server.Options, grpcServer *grpc.Server) (err error) {
@@ -48,16 +97,43 @@ func main() {
 		pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
 		return nil
 	}
-	s.HandleGRPC(grpcHandler)
+	if err := s.HandleGRPC(grpcHandler); err != nil {
+		log.Fatalf("failed to register grpc services: %v", err)
+	}
+
+	// forward the methods of remote services without code generation, like
+	// grpc_pass of nginx
+	for _, route := range strings.Split(*grpcProxy, ";") {
+		if route = strings.TrimSpace(route); route == "" {
+			continue
+		}
+		parts := strings.SplitN(route, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("invalid proxy route %q, expected prefix=backends", route)
+		}
+		upstream := server.ParseBackends(parts[1])
+		upstream.Balancer = *balancer
+		upstream.HealthCheck = true
+		upstream.Dopts = []grpc.DialOption{grpc.WithInsecure()}
+		if err := s.HandleProxy(parts[0], upstream); err != nil {
+			log.Fatalf("failed to register proxy: %v", err)
+		}
+	}
 
 	// handle rest gateway services
 	restHandler := func(opts *server.Options) (route *http.ServeMux, err error) {
-		logrus.Infof("Register gateway to %s", opts.GrpcAddr)
+		conn := opts.Conn
+		if opts.Backend != nil {
+			logrus.Infof("Register gateway to GRPC backends %s", *backends)
+			conn = opts.Backend
+		} else {
+			logrus.Info("Register gateway to in-process GRPC server")
+		}
 		route = http.NewServeMux()
 
 		pingpongMux := runtime.NewServeMux()
 		ctx := context.Background()
-		err = pingpong.RegisterPingPongHandlerFromEndpoint(ctx, pingpongMux, opts.GrpcAddr, opts.Dopts)
+		err = pingpong.RegisterPingPongHandler(ctx, pingpongMux, conn)
 		if err != nil {
 			logrus.Infof("cannot serve pingpong api: %v\n", err)
 			return route, err
@@ -65,12 +141,34 @@ func main() {
 		route.Handle("/", pingpongMux)
 		return route, nil
 	}
-	s.Handle("/pingpong/", restHandler)
+	if err := s.HandleGateway("/pingpong/", restHandler, "pingpong.PingPong"); err != nil {
+		log.Fatalf("failed to register route: %v", err)
+	}
+	if err := s.AddSwagger("/pingpong/", []byte(pingpong.Swagger)); err != nil {
+		log.Fatalf("failed to add swagger: %v", err)
+	}
+
+	// bridge the streaming methods for browsers via SSE, ndjson and websockets
+	streamRoute := server.StreamBridge(map[string]server.StreamMethod{
+		"/ping": {
+			Method:      "/pingpong.PingPong/PingStream",
+			NewRequest:  func() proto.Message { return &pingpong.PingStreamRequest{} },
+			NewResponse: func() proto.Message { return &pingpong.PongReply{} },
+		},
+		"/pingpong": {
+			Method:        "/pingpong.PingPong/PingPongStream",
+			NewRequest:    func() proto.Message { return &pingpong.PingRequest{} },
+			NewResponse:   func() proto.Message { return &pingpong.PongReply{} },
+			ClientStreams: true,
+		},
+	})
+	if err := s.Handle("/stream/", streamRoute); err != nil {
+		log.Fatalf("failed to register route: %v", err)
+	}
 
 	// handle optional mux handles
 	custsomRoute := func(opts *server.Options) (mux *http.ServeMux, err error) {
 		mux = http.NewServeMux()
-		// TODO: read all scopes from pb.Swagger
 		mux.HandleFunc("/rest", func(w http.ResponseWriter, req *http.Request) {
 			io.Copy(w, strings.NewReader("pingpong"))
 		})
@@ -78,11 +176,27 @@ func main() {
 		return mux, nil
 	}
 
-	s.Handle("/custom/", custsomRoute)
+	if err := s.Handle("/custom/", custsomRoute); err != nil {
+		log.Fatalf("failed to register route: %v", err)
+	}
+
+	// drain in-flight requests on SIGINT/SIGTERM
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		logrus.Infof("received %v", <-sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			logrus.Errorf("failed to shutdown gracefully: %v", err)
+		}
+	}()
 
-	// Register reflection service on gRPC server.
-	// reflection.Register(s.GRPC)
 	if err := s.Serve(); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	<-done
 }
@@ -9,6 +9,8 @@ import (
 	"github.com/chris-rock/gyrpsy/api/pingpong"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var port = 5001
@@ -39,10 +41,16 @@ func main() {
 		grpc.Creds(creds),
 	}
 
+	// both servers report the overall status and the status of the pingpong
+	// service, eg. for Backends.HealthService of the gateway
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("pingpong.PingPong", healthpb.HealthCheckResponse_SERVING)
+
 	// start grpc server without tls cert
 	go func() {
 		grpcServer := grpc.NewServer()
 		pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
+		healthpb.RegisterHealthServer(grpcServer, healthServer)
 		lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", portWithoutTls))
 		if err != nil {
 			panic(err)
@@ -56,6 +64,7 @@ func main() {
 	// start grpc server with tls cert
 	grpcServer := grpc.NewServer(opts...)
 	pingpong.RegisterPingPongServer(grpcServer, &pingpong.PingPongServerImpl{})
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
 
 	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
 	if err != nil {
@@ -3,22 +3,29 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/chris-rock/gyrpsy/api/pingpong"
 	server "github.com/chris-rock/gyrpsy/gateway/go/gateway"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var httpsport = 5002
 var httpport = 5003
 var grpcPort = 5001
+var callTimeout = 5 * time.Second
+
+var backends = flag.String("backends", "", "comma separated pp_grpc addresses or a resolver target like dns:///pp-grpc:5001, defaults to localhost:5001")
+var balancer = flag.String("balancer", server.RoundRobin, "balancer of the backends, round_robin or gateway_least_request")
 
 func GetCertificates(keyFilename string, certFilename string) (ko []byte, co []byte) {
 	// load key from disk
@@ -37,6 +44,8 @@ func GetCertificates(keyFilename string, certFilename string) (ko []byte, co []b
 }
 
 func main() {
+	flag.Parse()
+
 	HttpAddr := fmt.Sprintf("localhost:%d", httpport)
 	HttpsAddr := fmt.Sprintf("localhost:%d", httpsport)
 	GrpcAddr := fmt.Sprintf("localhost:%d", grpcPort)
@@ -49,23 +58,69 @@ func main() {
 	key, cert := GetCertificates("./cert/key_localhost_5001.pem", "./cert/cert_localhost_5001.pem")
 
 	// set up GRPC client
-	_, CertPool := server.LoadCert(cert, key)
+	_, CertPool, err := server.LoadCert(cert, key)
+	if err != nil {
+		panic(err)
+	}
 	dcreds := credentials.NewTLS(&tls.Config{
 		ServerName: GrpcAddr,
 		RootCAs:    CertPool,
 	})
 	opts := []grpc.DialOption{grpc.WithTransportCredentials(dcreds)}
 
+	// deadlines and retries of the backend calls, the Grpc-Timeout header of
+	// a request shortens the deadline
+	policies := []server.CallPolicy{
+		{Service: "pingpong.PingPong", Timeout: callTimeout, Retry: &server.RetryPolicy{}},
+	}
+	var conn *grpc.ClientConn
+	if *backends != "" {
+		// balance across several pp_grpc instances, unhealthy ones are skipped
+		b := server.ParseBackends(*backends)
+		b.Balancer = *balancer
+		b.HealthCheck = true
+		conn, err = b.Dial(policies, opts...)
+	} else {
+		var popts []grpc.DialOption
+		popts, err = server.CallPolicyDialOptions(policies)
+		if err != nil {
+			panic(err)
+		}
+		conn, err = grpc.Dial(GrpcAddr, append(opts, popts...)...)
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
 	// start mux and attach the grpc client
-	mux := runtime.NewServeMux()
-	err := pingpong.RegisterPingPongHandlerFromEndpoint(ctx, mux, GrpcAddr, opts)
+	gwmux := runtime.NewServeMux()
+	err = pingpong.RegisterPingPongHandler(ctx, gwmux, conn)
 	if err != nil {
 		panic(err)
 	}
 
+	// serve health routes next to the gateway, readiness reflects the grpc backend
+	mux := http.NewServeMux()
+	mux.Handle("/", gwmux)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		res, err := healthpb.NewHealthClient(conn).Check(r.Context(), &healthpb.HealthCheckRequest{})
+		if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+			http.Error(w, "grpc backend not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
 	// start https server with mux
 	rest_key, rest_cert := GetCertificates("./cert/key_localhost_5002.pem", "./cert/cert_localhost_5002.pem")
-	RestKeyPair, _ := server.LoadCert(rest_cert, rest_key)
+	RestKeyPair, _, err := server.LoadCert(rest_cert, rest_key)
+	if err != nil {
+		panic(err)
+	}
 
 	// start http server in its own go routine
 	go func() {